@@ -1,76 +1,175 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"main/config"
 	"main/server"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"github.com/sirupsen/logrus"
 )
 
 var log = logrus.New()
 
 func main() {
-	dimFlag := flag.Int("d", 2, "Number of dimensions for this CAN server")
-	redFlag := flag.Int("r", 1, "Copies of data inserted")
-	port := flag.String("p", "3000", "Port to listen on")
-	join := flag.String("join", "", "IP:Port of existing server to join")
-	joinKey := flag.String("key", "", "Key for joining a CAN")
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration: " + err.Error())
+	}
 
-	flag.Parse()
+	level, _ := logrus.ParseLevel(cfg.LogLevel)
+	log.SetLevel(level)
+	server.SetLogLevel(level)
+
+	// Never wait on a terminal for a join key, so unattended starts cannot hang
+	if len(cfg.Seeds) > 0 && cfg.JoinKey == "" {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		cfg.JoinKey = hex.EncodeToString(buf)
+	}
+	fmt.Print("Effective configuration:\n" + cfg.String())
+
+	hasherName := cfg.Hasher
+	if hasherName == "" {
+		hasherName = server.DefaultHasher
+	}
+	hasher, _ := server.GetHasher(hasherName)
+	secret, err := server.LoadSecret(cfg.Secret, cfg.SecretFile)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Create region
-	serv := server.CreateServer(*dimFlag, *redFlag, *port)
-	if *join != "" {
-		key := *joinKey
-		if key == "" {
-			fmt.Print("What key to use to join server? ")
-			key, _ = bufio.NewReader(os.Stdin).ReadString('\n')
+	serv := server.CreateServer(cfg.Dimension, cfg.Redundancy, hasher, cfg.Placement, cfg.Port())
+	serv.Reg.Torus = cfg.Torus
+	serv.Reg.SplitStrategy = cfg.Split
+	serv.Secret = secret
+	serv.C.Timeout = cfg.Timeouts.Request
+	serv.JoinTimeout = cfg.Timeouts.Join
+	serv.Admission = server.NewAdmission(cfg.Limits.Inflight, cfg.Limits.Forwarded, cfg.Limits.ClientRate, cfg.Limits.ClientBurst, cfg.Limits.MaxHops)
+	if cfg.Cache.Size > 0 {
+		serv.Cache = server.NewReadCache(cfg.Cache.Size, cfg.Cache.TTL)
+	}
+
+	idFile := ""
+	if cfg.DataDir != "" {
+		if err := os.MkdirAll(cfg.DataDir, 0700); err != nil {
+			log.Fatal(err)
+		}
+		idFile = filepath.Join(cfg.DataDir, "node.id")
+	}
+	if serv.ID, err = server.LoadNodeID(idFile); err != nil {
+		log.Fatal(err)
+	}
+
+	var tlsConf *tls.Config
+	if cfg.TLS.Cert != "" {
+		tlsConf, err = serv.ConfigureTLS(cfg.TLS.Cert, cfg.TLS.Key, cfg.TLS.CA, cfg.TLS.Mutual)
+		if err != nil {
+			log.Fatal(err)
 		}
-		serv.SendJoin(*join, *port, key)
-		// log.Print(serv.Reg)
-	}
-
-	// Configure the router and client
-	r := chi.NewRouter()
-	r.Use(middleware.Logger)
-	r.Use(middleware.RealIP)
-
-	// Endpoints
-	r.Route("/", func(r chi.Router) {
-		// Join a CAN
-		r.Post("/join", serv.Join)
-
-		// Get info from CAN Server
-		r.Get("/debug", serv.Debug)
-		r.Post("/trace", serv.RouteTrace)
-
-		// Interface with CAN Data
-		r.Route("/data", func(r chi.Router) {
-			r.Put("/", serv.PutData)            // Add data
-			r.Patch("/", serv.PatchData)        // Update Data
-			r.Get("/{key}", serv.GetData)       // Retrieve Data
-			r.Delete("/{key}", serv.DeleteData) // Delete Data
-		})
-
-		// Interface with CAN Neighbors
-		r.Route("/neighbors", func(r chi.Router) {
-			r.Put("/", serv.AddNeighbor)       // Add Neighbor
-			r.Patch("/", serv.PatchNeighbor)   // Update Neighbor
-			r.Delete("/", serv.DeleteNeighbor) // Delete Neighbor
-		})
-	})
-
-	r.Options("/*", serv.Options)
-	r.Options("/join", serv.JoinOptions)
-	r.Options("/data", serv.DataOptions)
-	r.Options("/debug", serv.DebugOptions)
-
-	log.Print("Server listening on port " + *port + "...")
-	http.ListenAndServe(":"+*port, r)
+	}
+
+	// Advertise after TLS is configured so the address carries our scheme
+	if cfg.Advertise != "" {
+		serv.Advertise = server.ParseHost(cfg.Advertise, serv.Scheme)
+	} else {
+		serv.Advertise = server.Host{Port: cfg.Port(), Scheme: serv.Scheme}
+	}
+	log.Print("Node " + serv.ID + " advertising " + serv.Advertise.String())
+
+	// A joiner started without a hasher takes the one its CAN was created with, before any request hashes a key
+	if cfg.Hasher == "" {
+		for _, seed := range cfg.Seeds {
+			if err := serv.AdoptHasher(seed); err != nil {
+				log.Warn("Could not read the hasher of the CAN through " + seed + ": " + err.Error())
+				continue
+			}
+			break
+		}
+	}
+
+	// Listen before joining, so requests the owner forwards once it commits the split find us
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Print("Server listening on " + cfg.Listen + "...")
+	srv := &http.Server{
+		Handler:           server.NewRouter(serv),
+		TLSConfig:         tlsConf,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+	}
+	served := make(chan error, 1)
+	go func() {
+		if tlsConf != nil {
+			served <- srv.ServeTLS(ln, "", "")
+		} else {
+			served <- srv.Serve(ln)
+		}
+	}()
+
+	// On a termination signal, stop reporting ready and let requests in flight finish
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		serv.SetState(server.StateLeaving)
+		log.Print("Shutting down...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Request)
+		defer cancel()
+		srv.Shutdown(ctx)
+		close(done)
+	}()
+
+	// Join through the first seed which accepts us, we only become ready once it commits
+	if len(cfg.Seeds) > 0 {
+		joined := false
+		for _, seed := range cfg.Seeds {
+			if err := serv.SendJoin(seed, cfg.JoinKey); err != nil {
+				log.Warn("Could not join through " + seed + ": " + err.Error())
+				continue
+			}
+			joined = true
+			break
+		}
+		if !joined {
+			log.Fatal("Could not join the CAN through any seed")
+		}
+	} else {
+		// The first server owns the whole space from the start
+		serv.SetState(server.StateReady)
+	}
+
+	// Evict expired data in the background
+	serv.StartReaper(cfg.Timeouts.Reap, nil)
+
+	// Repair neighbor tables with periodic updates
+	serv.StartRefresh(cfg.Timeouts.Refresh, nil)
+
+	// Bring replicas back in line after missed writes
+	serv.StartAntiEntropy(cfg.Timeouts.AntiEntropy, nil)
+
+	if err := <-served; err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
 }
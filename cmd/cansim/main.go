@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"main/server"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ways a joiner's zone is chosen
+const (
+	joinRandom = "random" // Split the zone owning a random point
+	joinVolume = "volume" // Split the largest of the owning zone and its neighbors
+)
+
+// node - A simulated CAN server
+type node struct {
+	id  string
+	reg *server.Region
+}
+
+// sim - A virtual CAN built by simulated joins, with no sockets
+type sim struct {
+	rnd     *rand.Rand
+	nodes   []*node
+	byID    map[string]*node
+	hopCap  int
+	failed  int // Routes which gave up before reaching an owner
+	dropped int // Joins which never reached an owner, so their nodes are missing from the CAN
+	joinHow string
+	keys    []string // Keys stored before joins, for data-aware splits
+}
+
+// summary - Distribution statistics of one measurement over the nodes or routes
+type summary struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+	Skew   float64 `json:"skew"` // Largest value relative to the mean
+}
+
+// report - Everything measured by one simulation run
+type report struct {
+	Nodes          int         `json:"nodes"`
+	Dimension      int         `json:"dimension"`
+	Torus          bool        `json:"torus"`
+	Join           string      `json:"join"`
+	Split          string      `json:"split"`
+	Hasher         string      `json:"hasher"`
+	Keys           int         `json:"keys"`
+	FailedRoutes   int         `json:"failedRoutes"`
+	DroppedJoins   int         `json:"droppedJoins"`
+	PathLength     summary     `json:"pathLength"`
+	PathHistogram  map[int]int `json:"pathHistogram"`
+	ZoneVolume     summary     `json:"zoneVolume"`
+	KeysPerNode    summary     `json:"keysPerNode"`
+	NeighborCount  summary     `json:"neighborCount"`
+	NeighborCounts map[int]int `json:"neighborHistogram"`
+}
+
+func main() {
+	num := flag.Int("n", 10000, "Number of nodes to build the CAN from")
+	dim := flag.Int("d", 2, "Number of dimensions")
+	torus := flag.Bool("torus", false, "Wrap each side of the space around to the opposite side")
+	join := flag.String("join", joinRandom, "How joiners choose the zone to split ("+joinRandom+", "+joinVolume+")")
+	split := flag.String("split", server.DefaultSplit, "Strategy for cutting zones ("+strings.Join(server.SplitNames(), ", ")+")")
+	hashName := flag.String("hash", server.DefaultHasher, "Hasher mapping keys to points ("+strings.Join(server.HasherNames(), ", ")+")")
+	keys := flag.Int("keys", 100000, "Number of random keys to route and store")
+	seed := flag.Int64("seed", 1, "Seed for joins, keys and route entry points")
+	format := flag.String("format", "json", "Output format (json, csv)")
+	flag.Parse()
+
+	hasher, err := server.GetHasher(*hashName)
+	if err != nil {
+		fail(err)
+	}
+	if err := server.CheckSplit(*split); err != nil {
+		fail(err)
+	}
+	if *join != joinRandom && *join != joinVolume {
+		fail(fmt.Errorf("Unknown join placement %s", *join))
+	}
+	if *num < 1 || *dim < 1 {
+		fail(fmt.Errorf("Need at least one node and one dimension"))
+	}
+
+	s := &sim{
+		rnd:     rand.New(rand.NewSource(*seed)),
+		byID:    make(map[string]*node),
+		joinHow: *join,
+	}
+	// Greedy routes take about (d/4)n^(1/d) hops, allow plenty more before calling a route lost
+	s.hopCap = 10*int(float64(*dim)*math.Pow(float64(*num), 1/float64(*dim))) + 100
+
+	first := &node{id: nodeID(0), reg: server.CreateRegion(*dim, 1, hasher, server.PlacementHashed)}
+	first.reg.Torus = *torus
+	first.reg.SplitStrategy = *split
+	s.add(first)
+
+	// Data-aware splits need the keys in place while the CAN is built
+	if *split == server.SplitMedian {
+		s.load(first, *keys)
+	}
+	for i := 1; i < *num; i++ {
+		s.join(nodeID(i))
+	}
+
+	rep := s.measure(*keys)
+	rep.Torus = *torus
+	rep.Join = *join
+	rep.Split = *split
+	rep.Hasher = hasher.Name()
+
+	if *format == "csv" {
+		writeCSV(rep)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+	}
+
+	// A run which lost routes or joins is a routing failure, so it fails the run for scripts checking it
+	if rep.FailedRoutes > 0 || rep.DroppedJoins > 0 {
+		fail(fmt.Errorf("%d routes failed and %d joins were dropped", rep.FailedRoutes, rep.DroppedJoins))
+	}
+}
+
+// fail - Print an error and exit
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// nodeID - The ID of the i-th node to join
+func nodeID(i int) string {
+	return "n" + strconv.Itoa(i)
+}
+
+// add - Track a node in the CAN
+func (s *sim) add(n *node) {
+	s.nodes = append(s.nodes, n)
+	s.byID[n.id] = n
+}
+
+// load - Store random keys in a node before any joins, so splits move them along with their zones
+func (s *sim) load(n *node, keys int) {
+	for i := 0; i < keys; i++ {
+		key := s.randomKey()
+		rec := server.NewRecord(nil, "")
+		if _, err := n.reg.UpsertData(n.reg.HashKey(key), key, &rec, server.Precondition{}); err != nil {
+			fail(err)
+		}
+		s.keys = append(s.keys, key)
+	}
+}
+
+// randomKey - A random key
+func (s *sim) randomKey() string {
+	return strconv.FormatUint(s.rnd.Uint64(), 36)
+}
+
+// randomPoint - A uniformly random point in the unit space
+func (s *sim) randomPoint(dim int) server.Point {
+	coords := make([]float64, dim)
+	for i := range coords {
+		coords[i] = s.rnd.Float64()
+	}
+	return server.Point{Coords: coords}
+}
+
+// route - Greedily forward from a random node towards pt, as servers forward requests, returning
+// the owner and the number of hops taken
+func (s *sim) route(pt server.Point) (*node, int) {
+	cur := s.nodes[s.rnd.Intn(len(s.nodes))]
+	for hops := 0; hops <= s.hopCap; hops++ {
+		inReg, next := cur.reg.Locate(pt)
+		if inReg {
+			return cur, hops
+		}
+		cur = s.byID[next.ID]
+	}
+	return nil, s.hopCap
+}
+
+// join - Add a node by splitting a zone, then exchange the neighbor updates servers would send
+func (s *sim) join(id string) {
+	pt := s.randomPoint(s.nodes[0].reg.Dimension)
+	owner, _ := s.route(pt)
+	if owner == nil {
+		s.dropped++
+		return
+	}
+
+	// Volume balancing hands the join to the largest zone among the owner and its neighbors
+	if s.joinHow == joinVolume {
+		best := owner.reg.Space.Volume()
+		for nid, neighbor := range owner.reg.Neighbors {
+			if vol := neighbor.Space.Volume(); vol > best {
+				best = vol
+				owner = s.byID[nid]
+			}
+		}
+	}
+
+	newReg, delNeighbors := owner.reg.Split(server.Neighbor{ID: owner.id})
+	joiner := &node{id: id, reg: newReg}
+	s.add(joiner)
+
+	// The owner tells its remaining neighbors about its smaller zone
+	for nid := range owner.reg.Neighbors {
+		s.byID[nid].reg.Neighbors[owner.id] = server.Neighbor{ID: owner.id, Space: *owner.reg.Space.Copy(), Zone: owner.reg.Zone}
+	}
+
+	// Neighbors which no longer border the owner forget it
+	for _, neighbor := range delNeighbors {
+		delete(s.byID[neighbor.ID].reg.Neighbors, owner.id)
+	}
+
+	// The joiner introduces itself to its new neighbors, the owner among them
+	for nid := range joiner.reg.Neighbors {
+		s.byID[nid].reg.Neighbors[joiner.id] = server.Neighbor{ID: joiner.id, Space: *joiner.reg.Space.Copy(), Zone: joiner.reg.Zone}
+	}
+}
+
+// measure - Route random keys from random nodes and collect statistics over the CAN
+func (s *sim) measure(keys int) *report {
+	rep := &report{
+		Nodes:          len(s.nodes),
+		Dimension:      s.nodes[0].reg.Dimension,
+		Keys:           keys,
+		PathHistogram:  make(map[int]int),
+		NeighborCounts: make(map[int]int),
+	}
+
+	held := make(map[string]int)
+	paths := []float64{}
+	for i := 0; i < keys; i++ {
+		var key string
+		if i < len(s.keys) {
+			key = s.keys[i]
+		} else {
+			key = s.randomKey()
+		}
+		owner, hops := s.route(s.nodes[0].reg.HashKey(key))
+		if owner == nil {
+			s.failed++
+			continue
+		}
+		held[owner.id]++
+		paths = append(paths, float64(hops))
+		rep.PathHistogram[hops]++
+	}
+
+	volumes := make([]float64, 0, len(s.nodes))
+	perNode := make([]float64, 0, len(s.nodes))
+	neighbors := make([]float64, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		volumes = append(volumes, n.reg.Space.Volume())
+		perNode = append(perNode, float64(held[n.id]))
+		neighbors = append(neighbors, float64(len(n.reg.Neighbors)))
+		rep.NeighborCounts[len(n.reg.Neighbors)]++
+	}
+
+	rep.FailedRoutes = s.failed
+	rep.DroppedJoins = s.dropped
+	rep.PathLength = summarize(paths)
+	rep.ZoneVolume = summarize(volumes)
+	rep.KeysPerNode = summarize(perNode)
+	rep.NeighborCount = summarize(neighbors)
+	return rep
+}
+
+// summarize - Compute distribution statistics over a sample
+func summarize(vals []float64) summary {
+	sum := summary{Count: len(vals)}
+	if len(vals) == 0 {
+		return sum
+	}
+
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+	sum.Min = sorted[0]
+	sum.Max = sorted[len(sorted)-1]
+
+	total := 0.0
+	for _, v := range sorted {
+		total += v
+	}
+	sum.Mean = total / float64(len(sorted))
+
+	variance := 0.0
+	for _, v := range sorted {
+		variance += (v - sum.Mean) * (v - sum.Mean)
+	}
+	sum.StdDev = math.Sqrt(variance / float64(len(sorted)))
+
+	sum.P50 = percentile(sorted, 0.5)
+	sum.P90 = percentile(sorted, 0.9)
+	sum.P99 = percentile(sorted, 0.99)
+	if sum.Mean > 0 {
+		sum.Skew = sum.Max / sum.Mean
+	}
+	return sum
+}
+
+// percentile - The value below which a fraction q of a sorted sample falls
+func percentile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// writeCSV - Write a report as metric, statistic, value rows
+func writeCSV(rep *report) {
+	w := csv.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	w.Write([]string{"metric", "statistic", "value"})
+	w.Write([]string{"run", "nodes", strconv.Itoa(rep.Nodes)})
+	w.Write([]string{"run", "dimension", strconv.Itoa(rep.Dimension)})
+	w.Write([]string{"run", "torus", strconv.FormatBool(rep.Torus)})
+	w.Write([]string{"run", "join", rep.Join})
+	w.Write([]string{"run", "split", rep.Split})
+	w.Write([]string{"run", "hasher", rep.Hasher})
+	w.Write([]string{"run", "keys", strconv.Itoa(rep.Keys)})
+	w.Write([]string{"run", "failedRoutes", strconv.Itoa(rep.FailedRoutes)})
+	w.Write([]string{"run", "droppedJoins", strconv.Itoa(rep.DroppedJoins)})
+
+	for _, m := range []struct {
+		name string
+		sum  summary
+	}{
+		{"pathLength", rep.PathLength},
+		{"zoneVolume", rep.ZoneVolume},
+		{"keysPerNode", rep.KeysPerNode},
+		{"neighborCount", rep.NeighborCount},
+	} {
+		for _, stat := range []struct {
+			name string
+			val  float64
+		}{
+			{"min", m.sum.Min}, {"max", m.sum.Max}, {"mean", m.sum.Mean}, {"stddev", m.sum.StdDev},
+			{"p50", m.sum.P50}, {"p90", m.sum.P90}, {"p99", m.sum.P99}, {"skew", m.sum.Skew},
+		} {
+			w.Write([]string{m.name, stat.name, strconv.FormatFloat(stat.val, 'g', -1, 64)})
+		}
+	}
+
+	writeHistogram(w, "pathHistogram", rep.PathHistogram)
+	writeHistogram(w, "neighborHistogram", rep.NeighborCounts)
+}
+
+// writeHistogram - Write a histogram's buckets in increasing order
+func writeHistogram(w *csv.Writer, name string, hist map[int]int) {
+	buckets := make([]int, 0, len(hist))
+	for b := range hist {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+	for _, b := range buckets {
+		w.Write([]string{name, strconv.Itoa(b), strconv.Itoa(hist[b])})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"main/server"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:3000", "IP:Port of any server in the CAN, prefixed with https:// for TLS")
+	caFile := flag.String("tls-ca", "", "CA bundle trusted when connecting to servers over HTTPS")
+	timeout := flag.Duration("timeout", 10*time.Second, "Time allowed for each request")
+	flag.Parse()
+
+	client := &http.Client{Timeout: *timeout}
+	if *caFile != "" {
+		pem, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			fail(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fail(fmt.Errorf("No certificates found in %s", *caFile))
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	vRes := server.VerifyFrom(client, server.ParseHost(*addr, ""))
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(vRes)
+
+	// Exit with an error when any invariant is broken so scripts can act on it
+	if !vRes.OK {
+		os.Exit(1)
+	}
+}
+
+// fail - Print an error and exit
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
+}
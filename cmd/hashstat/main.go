@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"main/server"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+// stats - Uniformity measurements for one hasher over a sample of keys
+type stats struct {
+	hasher      string
+	keys        int
+	chiSquare   []float64 // Per dimension, over the buckets of that axis
+	gridChi     float64   // Over the joint grid of all dimensions
+	gridCells   int
+	maxSkew     float64 // Fullest grid cell relative to the expected count
+	correlation float64 // Largest absolute correlation between any two dimensions
+}
+
+func main() {
+	dim := flag.Int("d", 2, "Number of dimensions to hash into")
+	hashName := flag.String("hash", "", "Hasher to report on, all hashers if empty")
+	buckets := flag.Int("b", 16, "Buckets per dimension")
+	num := flag.Int("n", 100000, "Number of random keys to generate when no file is given")
+	seed := flag.Int64("seed", 1, "Seed for generated keys")
+	file := flag.String("f", "", "File of newline separated keys to sample, - for stdin")
+	flag.Parse()
+
+	// Every report divides keys among buckets along each dimension
+	if *dim < 1 || *buckets < 1 || (*file == "" && *num < 1) {
+		fmt.Fprintln(os.Stderr, "-d, -b and -n must each be at least 1")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keys, err := loadKeys(*file, *num, *seed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	names := server.HasherNames()
+	if *hashName != "" {
+		names = []string{*hashName}
+	}
+
+	for _, name := range names {
+		hasher, err := server.GetHasher(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		report(measure(hasher, keys, *dim, *buckets), *buckets)
+	}
+}
+
+// loadKeys - Read keys from a file or stdin, or generate n random keys
+func loadKeys(file string, n int, seed int64) ([]string, error) {
+	if file == "" {
+		rng := rand.New(rand.NewSource(seed))
+		keys := make([]string, n)
+		for i := range keys {
+			keys[i] = "key-" + strconv.FormatInt(rng.Int63(), 36)
+		}
+		return keys, nil
+	}
+
+	in := os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	keys := []string{}
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		if file == "-" {
+			file = "stdin"
+		}
+		return nil, errors.New("No keys to sample in " + file)
+	}
+	return keys, nil
+}
+
+// measure - Hash every key and compute how evenly the points fill the space
+func measure(hasher server.Hasher, keys []string, dim, buckets int) stats {
+	st := stats{
+		hasher:    hasher.Name(),
+		keys:      len(keys),
+		chiSquare: make([]float64, dim),
+	}
+
+	// Cap the joint grid so high dimensions stay cheap
+	st.gridCells = 1
+	for i := 0; i < dim && st.gridCells*buckets <= 1<<20; i++ {
+		st.gridCells *= buckets
+	}
+
+	axisCounts := make([][]int, dim)
+	for i := range axisCounts {
+		axisCounts[i] = make([]int, buckets)
+	}
+	gridCounts := make([]int, st.gridCells)
+	points := make([][]float64, len(keys))
+
+	for k, key := range keys {
+		pt := hasher.HashToPoint(key, dim)
+		points[k] = pt.Coords
+
+		cell, stride := 0, 1
+		for i, val := range pt.Coords {
+			if val < 0 || val >= 1 {
+				fmt.Fprintf(os.Stderr, "%s: key %q hashed outside [0,1): %v\n", hasher.Name(), key, val)
+				os.Exit(1)
+			}
+			b := int(val * float64(buckets))
+			axisCounts[i][b]++
+			if stride < st.gridCells {
+				cell += b * stride
+				stride *= buckets
+			}
+		}
+		gridCounts[cell]++
+	}
+
+	for i, counts := range axisCounts {
+		st.chiSquare[i] = chiSquare(counts, len(keys))
+	}
+	st.gridChi = chiSquare(gridCounts, len(keys))
+
+	expected := float64(len(keys)) / float64(st.gridCells)
+	for _, count := range gridCounts {
+		st.maxSkew = math.Max(st.maxSkew, float64(count)/expected)
+	}
+
+	for i := 0; i < dim; i++ {
+		for j := i + 1; j < dim; j++ {
+			st.correlation = math.Max(st.correlation, math.Abs(correlation(points, i, j)))
+		}
+	}
+
+	return st
+}
+
+// chiSquare - Pearson's chi-squared statistic of counts against a uniform distribution
+func chiSquare(counts []int, total int) float64 {
+	expected := float64(total) / float64(len(counts))
+	sum := 0.0
+	for _, count := range counts {
+		diff := float64(count) - expected
+		sum += diff * diff / expected
+	}
+	return sum
+}
+
+// correlation - Pearson correlation between coordinates i and j of a set of points
+func correlation(points [][]float64, i, j int) float64 {
+	n := float64(len(points))
+	var sumI, sumJ, sumII, sumJJ, sumIJ float64
+	for _, pt := range points {
+		sumI += pt[i]
+		sumJ += pt[j]
+		sumII += pt[i] * pt[i]
+		sumJJ += pt[j] * pt[j]
+		sumIJ += pt[i] * pt[j]
+	}
+	cov := sumIJ/n - (sumI/n)*(sumJ/n)
+	varI := sumII/n - (sumI/n)*(sumI/n)
+	varJ := sumJJ/n - (sumJ/n)*(sumJ/n)
+	if varI == 0 || varJ == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varI*varJ)
+}
+
+// report - Print the measurements for a hasher
+func report(st stats, buckets int) {
+	fmt.Printf("%s: %d keys\n", st.hasher, st.keys)
+	for i, chi := range st.chiSquare {
+		fmt.Printf("  dim %d: chi-square %.2f (%d degrees of freedom)\n", i, chi, buckets-1)
+	}
+	fmt.Printf("  grid:  chi-square %.2f (%d degrees of freedom), fullest cell %.2fx expected\n", st.gridChi, st.gridCells-1, st.maxSkew)
+	fmt.Printf("  max correlation between dimensions: %.4f\n", st.correlation)
+}
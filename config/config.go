@@ -0,0 +1,362 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/server"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix - Prefix of environment variables overriding settings
+const envPrefix = "GOCAN_"
+
+// redacted - Shown in place of secrets when printing a config
+const redacted = "<redacted>"
+
+// TLSConfig - Certificates used to serve and reach other servers over HTTPS
+type TLSConfig struct {
+	Cert   string `yaml:"cert"`
+	Key    string `yaml:"key"`
+	CA     string `yaml:"ca"`
+	Mutual bool   `yaml:"mutual"`
+}
+
+// Timeouts - Limits on how long network operations and background work may take
+type Timeouts struct {
+	Request     time.Duration `yaml:"request"`     // Requests sent to other servers
+	ReadHeader  time.Duration `yaml:"readHeader"`  // Reading the headers of incoming requests
+	Reap        time.Duration `yaml:"reap"`        // Interval between evictions of expired data
+	Refresh     time.Duration `yaml:"refresh"`     // Interval between updates sent to neighbors
+	AntiEntropy time.Duration `yaml:"antiEntropy"` // Interval between Merkle tree exchanges with replica partners
+	Join        time.Duration `yaml:"join"`        // Time a joiner has to commit before its split is rolled back
+}
+
+// Limits - Admission control for data requests, a zero limit is not enforced
+type Limits struct {
+	Inflight    int `yaml:"inflight"`    // Client requests handled at once
+	Forwarded   int `yaml:"forwarded"`   // Requests forwarded by other servers handled at once
+	ClientRate  int `yaml:"clientRate"`  // Requests per second allowed from each client IP
+	ClientBurst int `yaml:"clientBurst"` // Requests a client may send at once, the rate when zero
+	MaxHops     int `yaml:"maxHops"`     // Servers a request may be forwarded through, server.DefaultMaxHops when zero
+}
+
+// Cache - Read cache for keys this server forwards requests for
+type Cache struct {
+	Size int           `yaml:"size"` // Responses held, no cache when zero
+	TTL  time.Duration `yaml:"ttl"`  // Time a cached response is served for
+}
+
+// Config - Every setting of a CAN server
+type Config struct {
+	Listen     string    `yaml:"listen"`
+	Advertise  string    `yaml:"advertise"`
+	Seeds      []string  `yaml:"seeds"`
+	JoinKey    string    `yaml:"joinKey"`
+	Dimension  int       `yaml:"dimension"`
+	Redundancy int       `yaml:"redundancy"`
+	Hasher     string    `yaml:"hasher"` // Empty to take the CAN's when joining, or server.DefaultHasher when creating one
+	Placement  string    `yaml:"placement"`
+	Torus      bool      `yaml:"torus"`
+	Split      string    `yaml:"split"`
+	LogLevel   string    `yaml:"logLevel"`
+	DataDir    string    `yaml:"dataDir"`
+	Timeouts   Timeouts  `yaml:"timeouts"`
+	Limits     Limits    `yaml:"limits"`
+	Cache      Cache     `yaml:"cache"`
+	Secret     string    `yaml:"secret"`
+	SecretFile string    `yaml:"secretFile"`
+	TLS        TLSConfig `yaml:"tls"`
+}
+
+// Default - The settings used when nothing overrides them
+func Default() *Config {
+	return &Config{
+		Listen:     ":3000",
+		Dimension:  2,
+		Redundancy: 1,
+		Placement:  server.PlacementHashed,
+		Split:      server.DefaultSplit,
+		LogLevel:   logrus.InfoLevel.String(),
+		Timeouts: Timeouts{
+			Request:     10 * time.Second,
+			ReadHeader:  10 * time.Second,
+			Reap:        10 * time.Second,
+			Refresh:     30 * time.Second,
+			AntiEntropy: time.Minute,
+			Join:        server.DefaultJoinTimeout,
+		},
+		Limits: Limits{
+			Inflight:  256,
+			Forwarded: 256,
+			MaxHops:   server.DefaultMaxHops,
+		},
+		Cache: Cache{
+			TTL: server.DefaultCacheTTL,
+		},
+	}
+}
+
+// setting - A single setting, reachable as a flag, an environment variable and a field of a config
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	field func(c *Config) interface{} // Pointer to the setting's field in c
+}
+
+// settings - Every setting which may be overridden by flags and the environment
+var settings = []setting{
+	{"listen", "LISTEN", "Address to listen on", func(c *Config) interface{} { return &c.Listen }},
+	{"advertise", "ADVERTISE", "IP:Port other servers reach this one at, defaults to the sender's IP and the listening port", func(c *Config) interface{} { return &c.Advertise }},
+	{"join", "SEEDS", "Comma separated IP:Port of existing servers to join, tried in order", func(c *Config) interface{} { return &c.Seeds }},
+	{"key", "JOIN_KEY", "Key for joining a CAN, random when unset", func(c *Config) interface{} { return &c.JoinKey }},
+	{"d", "DIMENSION", "Number of dimensions for this CAN server", func(c *Config) interface{} { return &c.Dimension }},
+	{"r", "REDUNDANCY", "Copies of data inserted", func(c *Config) interface{} { return &c.Redundancy }},
+	{"hash", "HASHER", "Hasher for keys when creating a CAN (" + strings.Join(server.HasherNames(), ", ") + "), default " + server.DefaultHasher + ", joiners take the CAN's unless set", func(c *Config) interface{} { return &c.Hasher }},
+	{"placement", "PLACEMENT", "Key placement when creating a CAN (" + strings.Join(server.PlacementNames(), ", ") + ")", func(c *Config) interface{} { return &c.Placement }},
+	{"torus", "TORUS", "Wrap each side of the space around to the opposite side when creating a CAN", func(c *Config) interface{} { return &c.Torus }},
+	{"split", "SPLIT", "Strategy for cutting zones between owners and joiners when creating a CAN (" + strings.Join(server.SplitNames(), ", ") + ")", func(c *Config) interface{} { return &c.Split }},
+	{"log-level", "LOG_LEVEL", "Lowest level of log messages written", func(c *Config) interface{} { return &c.LogLevel }},
+	{"data-dir", "DATA_DIR", "Directory holding this server's node ID, the ID is random per run when unset", func(c *Config) interface{} { return &c.DataDir }},
+	{"request-timeout", "REQUEST_TIMEOUT", "Time allowed for requests to other servers", func(c *Config) interface{} { return &c.Timeouts.Request }},
+	{"read-header-timeout", "READ_HEADER_TIMEOUT", "Time allowed to read the headers of incoming requests", func(c *Config) interface{} { return &c.Timeouts.ReadHeader }},
+	{"reap", "REAP_INTERVAL", "Interval between evictions of expired data", func(c *Config) interface{} { return &c.Timeouts.Reap }},
+	{"refresh", "REFRESH_INTERVAL", "Interval between zone and neighbor table updates sent to neighbors", func(c *Config) interface{} { return &c.Timeouts.Refresh }},
+	{"anti-entropy", "ANTI_ENTROPY_INTERVAL", "Interval between Merkle tree exchanges with replica partners", func(c *Config) interface{} { return &c.Timeouts.AntiEntropy }},
+	{"join-timeout", "JOIN_TIMEOUT", "Time a joiner has to commit before the split reserved for it is rolled back", func(c *Config) interface{} { return &c.Timeouts.Join }},
+	{"max-inflight", "MAX_INFLIGHT", "Client data requests handled at once, beyond which requests get 503", func(c *Config) interface{} { return &c.Limits.Inflight }},
+	{"max-forwarded", "MAX_FORWARDED", "Data requests forwarded by other servers handled at once, beyond which they get 503", func(c *Config) interface{} { return &c.Limits.Forwarded }},
+	{"client-rate", "CLIENT_RATE", "Data requests per second allowed from each client IP, beyond which requests get 429", func(c *Config) interface{} { return &c.Limits.ClientRate }},
+	{"client-burst", "CLIENT_BURST", "Data requests a client may send at once above its rate, the rate when zero", func(c *Config) interface{} { return &c.Limits.ClientBurst }},
+	{"max-hops", "MAX_HOPS", "Servers a data request may be forwarded through before it gets 508", func(c *Config) interface{} { return &c.Limits.MaxHops }},
+	{"cache-size", "CACHE_SIZE", "Responses to forwarded reads cached for hot keys, no cache when zero", func(c *Config) interface{} { return &c.Cache.Size }},
+	{"cache-ttl", "CACHE_TTL", "Time a cached read is served for", func(c *Config) interface{} { return &c.Cache.TTL }},
+	{"secret", "SECRET", "Cluster secret for signing join and neighbor messages", func(c *Config) interface{} { return &c.Secret }},
+	{"secret-file", "SECRET_FILE", "File containing the cluster secret, overrides -secret", func(c *Config) interface{} { return &c.SecretFile }},
+	{"tls-cert", "TLS_CERT", "Certificate file, serves and sends all traffic over HTTPS when set", func(c *Config) interface{} { return &c.TLS.Cert }},
+	{"tls-key", "TLS_KEY", "Private key file for -tls-cert", func(c *Config) interface{} { return &c.TLS.Key }},
+	{"tls-ca", "TLS_CA", "CA bundle trusted when connecting to other servers", func(c *Config) interface{} { return &c.TLS.CA }},
+	{"mtls", "MTLS", "Require a certificate signed by -tls-ca for join and neighbor requests", func(c *Config) interface{} { return &c.TLS.Mutual }},
+}
+
+// listValue - A flag holding a comma separated list
+type listValue struct {
+	list *[]string
+}
+
+func (v listValue) String() string {
+	if v.list == nil {
+		return ""
+	}
+	return strings.Join(*v.list, ",")
+}
+
+func (v listValue) Set(s string) error {
+	*v.list = splitList(s)
+	return nil
+}
+
+// splitList - Split a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Load - Build the config from defaults, a config file, GOCAN_* environment variables and command line
+// flags, each taking precedence over the last
+//
+// The config file is named by -config or GOCAN_CONFIG.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("can", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "YAML config file")
+	port := fs.String("p", "", "Port to listen on, shorthand for -listen :port")
+
+	// Flags are parsed into their own config so only the ones given override the others
+	flagged := Default()
+	for _, st := range settings {
+		switch p := st.field(flagged).(type) {
+		case *string:
+			fs.StringVar(p, st.flag, *p, st.usage)
+		case *int:
+			fs.IntVar(p, st.flag, *p, st.usage)
+		case *bool:
+			fs.BoolVar(p, st.flag, *p, st.usage)
+		case *time.Duration:
+			fs.DurationVar(p, st.flag, *p, st.usage)
+		case *[]string:
+			fs.Var(listValue{p}, st.flag, st.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if *file != "" {
+		contents, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(contents, cfg); err != nil {
+			return nil, fmt.Errorf("Invalid config file %s: %v", *file, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, st := range settings {
+		if given[st.flag] {
+			copySetting(st.field(cfg), st.field(flagged))
+		}
+	}
+	if given["p"] {
+		cfg.Listen = ":" + *port
+	}
+
+	return cfg, nil
+}
+
+// applyEnv - Override settings with any GOCAN_* environment variables which are set
+func (c *Config) applyEnv() error {
+	for _, st := range settings {
+		val, ok := os.LookupEnv(envPrefix + st.env)
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch p := st.field(c).(type) {
+		case *string:
+			*p = val
+		case *int:
+			*p, err = strconv.Atoi(val)
+		case *bool:
+			*p, err = strconv.ParseBool(val)
+		case *time.Duration:
+			*p, err = time.ParseDuration(val)
+		case *[]string:
+			*p = splitList(val)
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid %s%s: %v", envPrefix, st.env, err)
+		}
+	}
+	return nil
+}
+
+// copySetting - Copy the value a setting's field pointer src points at to dst
+func copySetting(dst, src interface{}) {
+	switch p := dst.(type) {
+	case *string:
+		*p = *src.(*string)
+	case *int:
+		*p = *src.(*int)
+	case *bool:
+		*p = *src.(*bool)
+	case *time.Duration:
+		*p = *src.(*time.Duration)
+	case *[]string:
+		*p = *src.(*[]string)
+	}
+}
+
+// Validate - Check that every setting holds a usable value
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("Invalid listen address %s: %v", c.Listen, err)
+	}
+	if c.Advertise != "" && server.ParseHost(c.Advertise, "").Port == "" {
+		return errors.New("Advertised address " + c.Advertise + " needs a port")
+	}
+	for _, seed := range c.Seeds {
+		if server.ParseHost(seed, "").Port == "" {
+			return errors.New("Seed " + seed + " needs a port")
+		}
+	}
+	if c.Dimension < 1 {
+		return errors.New("Dimension must be at least 1")
+	}
+	if c.Redundancy < 1 {
+		return errors.New("Redundancy must be at least 1")
+	}
+	if c.Hasher != "" {
+		if _, err := server.GetHasher(c.Hasher); err != nil {
+			return err
+		}
+	}
+	if err := server.CheckPlacement(c.Placement); err != nil {
+		return err
+	}
+	if err := server.CheckSplit(c.Split); err != nil {
+		return err
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.Timeouts.Request < 0 || c.Timeouts.ReadHeader < 0 {
+		return errors.New("Timeouts may not be negative")
+	}
+	if c.Timeouts.Reap <= 0 {
+		return errors.New("Reap interval must be positive")
+	}
+	if c.Timeouts.Refresh <= 0 {
+		return errors.New("Refresh interval must be positive")
+	}
+	if c.Timeouts.AntiEntropy <= 0 {
+		return errors.New("Anti-entropy interval must be positive")
+	}
+	if c.Timeouts.Join <= 0 {
+		return errors.New("Join timeout must be positive")
+	}
+	if c.Limits.Inflight < 0 || c.Limits.Forwarded < 0 || c.Limits.ClientRate < 0 || c.Limits.ClientBurst < 0 || c.Limits.MaxHops < 0 {
+		return errors.New("Limits may not be negative")
+	}
+	if c.Cache.Size < 0 {
+		return errors.New("Cache size may not be negative")
+	}
+	if c.Cache.TTL <= 0 {
+		return errors.New("Cache TTL must be positive")
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		return errors.New("TLS needs both a certificate and a key")
+	}
+	if c.TLS.Mutual && c.TLS.Cert == "" {
+		return errors.New("Mutual TLS requires a TLS certificate and key")
+	}
+	return nil
+}
+
+// Port - The port from the listening address
+func (c *Config) Port() string {
+	_, port, _ := net.SplitHostPort(c.Listen)
+	return port
+}
+
+// String - Format the config as YAML with secrets hidden
+func (c *Config) String() string {
+	shown := *c
+	if shown.Secret != "" {
+		shown.Secret = redacted
+	}
+	out, _ := yaml.Marshal(&shown)
+	return string(out)
+}
@@ -1,5 +1,7 @@
 package data
 
+import "time"
+
 type PointResponse struct {
 	Coords []float64 `json:"coords"`
 }
@@ -10,32 +12,112 @@ type RangeResponse struct {
 }
 
 type DataRequest struct {
-	Key   string `json:"key"`
-	Data  string `json:"data"`
-	Owner string `json:"owner"`
+	Key         string     `json:"key"`
+	Data        string     `json:"data"`
+	ContentType string     `json:"contentType,omitempty"`
+	TTL         int64      `json:"ttl,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	Version     *uint64    `json:"version,omitempty"`
+	Upsert      bool       `json:"upsert,omitempty"`
+	Owner       string     `json:"owner"`
 }
 
 type DataResponse struct {
-	Key     string    `json:"key"`
-	Data    string    `json:"data"`
-	Coords  []float64 `json:"coords"`
-	Message string    `json:"message"`
+	Key         string     `json:"key"`
+	Data        string     `json:"data,omitempty"`
+	ContentType string     `json:"contentType"`
+	Version     uint64     `json:"version"`
+	Size        int        `json:"size"`
+	Checksum    string     `json:"checksum"`
+	Created     time.Time  `json:"created"`
+	Modified    time.Time  `json:"modified"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Coords      []float64  `json:"coords"`
+	Message     string     `json:"message"`
+}
+
+type RecordResponse struct {
+	Value       []byte     `json:"value"`
+	ContentType string     `json:"contentType"`
+	Version     uint64     `json:"version"`
+	Size        int        `json:"size"`
+	Checksum    string     `json:"checksum"`
+	Created     time.Time  `json:"created"`
+	Modified    time.Time  `json:"modified"`
+	Expires     *time.Time `json:"expires,omitempty"`
 }
 
 type DebugResponse struct {
-	Dimension  int                      `json:"dimension"`
-	Redundancy int                      `json:"redundancy"`
-	Range      RangeResponse            `json:"range"`
-	Data       map[string]string        `json:"data"`
-	Neighbors  map[string]RangeResponse `json:"neighbors"`
+	ID         string                      `json:"id"`
+	Address    string                      `json:"address"`
+	Dimension  int                         `json:"dimension"`
+	Redundancy int                         `json:"redundancy"`
+	Hasher     string                      `json:"hasher"`
+	Placement  string                      `json:"placement"`
+	Torus      bool                        `json:"torus"`
+	Split      string                      `json:"split"`
+	History    []SplitStepResponse         `json:"history"`
+	Range      RangeResponse               `json:"range"`
+	Zone       string                      `json:"zone"`
+	Data       map[string]RecordResponse   `json:"data"`
+	Neighbors  map[string]NeighborResponse `json:"neighbors"`
+	Replicas   map[string]int              `json:"replicas,omitempty"`
+	Cache      *CacheStats                 `json:"cache,omitempty"`
+	Admission  *AdmissionStats             `json:"admission,omitempty"`
+}
+
+type AdmissionStats struct {
+	Limited    uint64 `json:"limited"`
+	Overloaded uint64 `json:"overloaded"`
+	HopLimited uint64 `json:"hopLimited"`
+	Untrusted  uint64 `json:"untrusted"`
+	MaxHops    int    `json:"maxHops"`
+}
+
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Size    int    `json:"size"`
 }
 
 type JoinResponse struct {
-	Dimension  int                      `json:"dimension"`
-	Redundancy int                      `json:"redundancy"`
-	Range      RangeResponse            `json:"range"`
-	Data       map[string]string        `json:"data"`
-	Neighbors  map[string]RangeResponse `json:"neighbors"`
+	Epoch      uint64                      `json:"epoch"`
+	Dimension  int                         `json:"dimension"`
+	Redundancy int                         `json:"redundancy"`
+	Hasher     string                      `json:"hasher"`
+	Placement  string                      `json:"placement"`
+	Torus      bool                        `json:"torus"`
+	Split      string                      `json:"split"`
+	History    []SplitStepResponse         `json:"history"`
+	Range      RangeResponse               `json:"range"`
+	Zone       string                      `json:"zone"`
+	Data       map[string]RecordResponse   `json:"data"`
+	Neighbors  map[string]NeighborResponse `json:"neighbors"`
+	Owner      string                      `json:"owner"`
+	Token      string                      `json:"token"`
+}
+
+type SplitStepResponse struct {
+	Axis  int     `json:"axis"`
+	At    float64 `json:"at"`
+	Lo    float64 `json:"lo"`
+	Hi    float64 `json:"hi"`
+	Upper bool    `json:"upper"`
+}
+
+type JoinCommitRequest struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+}
+
+type HealthResponse struct {
+	ID        string `json:"id"`
+	State     string `json:"state"`
+	Ready     bool   `json:"ready"`
+	Message   string `json:"message"`
+	Zone      string `json:"zone"`
+	Neighbors int    `json:"neighbors"`
 }
 
 type ErrorResponse struct {
@@ -43,14 +125,125 @@ type ErrorResponse struct {
 }
 
 type JoinRequest struct {
-	Key string `json:"key"`
+	Key      string           `json:"key"`
+	ID       string           `json:"id"`
+	Address  string           `json:"address"`
+	Manifest *ClusterManifest `json:"manifest,omitempty"`
+}
+
+type ClusterManifest struct {
+	Dimension   int    `json:"dimension"`
+	Redundancy  int    `json:"redundancy"`
+	Hasher      string `json:"hasher"`
+	Placement   string `json:"placement"`
+	Split       string `json:"split"`
+	Torus       bool   `json:"torus"`
+	Protocol    int    `json:"protocol"`
+	MinProtocol int    `json:"minProtocol"`
+	Node        string `json:"node,omitempty"` // ID of the server describing the CAN, not compared between servers
 }
 
 type NeighborRequest struct {
-	Port  string        `json:"port"`
-	Range RangeResponse `json:"range"`
+	ID      string        `json:"id"`
+	Address string        `json:"address"`
+	Range   RangeResponse `json:"range"`
+	Zone    string        `json:"zone"`
+	Epoch   uint64        `json:"epoch"`
+}
+
+type NeighborResponse struct {
+	ID      string        `json:"id"`
+	Address string        `json:"address"`
+	Range   RangeResponse `json:"range"`
+	Zone    string        `json:"zone"`
+	Epoch   uint64        `json:"epoch"`
+}
+
+type RefreshRequest struct {
+	ID        string             `json:"id"`
+	Address   string             `json:"address"`
+	Range     RangeResponse      `json:"range"`
+	Zone      string             `json:"zone"`
+	Epoch     uint64             `json:"epoch"`
+	Neighbors []NeighborResponse `json:"neighbors"`
 }
 
 type TraceResponse struct {
 	Route []string `json:"Route"`
 }
+
+type ScanItem struct {
+	Key         string `json:"key"`
+	Data        string `json:"data"`
+	ContentType string `json:"contentType"`
+}
+
+type ScanResponse struct {
+	Items  []ScanItem `json:"items"`
+	Cursor string     `json:"cursor,omitempty"`
+}
+
+type ZoneScanResponse struct {
+	Range     RangeResponse               `json:"range"`
+	Items     []ScanItem                  `json:"items"`
+	Neighbors map[string]NeighborResponse `json:"neighbors"`
+}
+
+type WatchEvent struct {
+	Type     string          `json:"type"`
+	Key      string          `json:"key,omitempty"`
+	Record   *RecordResponse `json:"record,omitempty"`
+	Range    *RangeResponse  `json:"range,omitempty"`
+	Location string          `json:"location,omitempty"`
+}
+
+type Violation struct {
+	Check   string `json:"check"`
+	Node    string `json:"node,omitempty"`
+	Other   string `json:"other,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+type VerifyResponse struct {
+	OK         bool        `json:"ok"`
+	Nodes      int         `json:"nodes"`
+	Keys       int         `json:"keys"`
+	Volume     float64     `json:"volume"`
+	Violations []Violation `json:"violations"`
+}
+
+type ReplicaEntry struct {
+	Key     string         `json:"key"`
+	Record  RecordResponse `json:"record"`
+	Deleted bool           `json:"deleted,omitempty"`
+}
+
+type ReplicaPushRequest struct {
+	Owner   string         `json:"owner"`
+	Entries []ReplicaEntry `json:"entries"`
+}
+
+type MerkleRequest struct {
+	Owner string        `json:"owner"`
+	Range RangeResponse `json:"range"`
+	Depth int           `json:"depth"`
+	Root  string        `json:"root"`
+}
+
+type MerkleResponse struct {
+	InSync bool       `json:"inSync"`
+	Levels [][]string `json:"levels,omitempty"`
+}
+
+type ReplicaDiffRequest struct {
+	Owner   string         `json:"owner"`
+	Range   RangeResponse  `json:"range"`
+	Depth   int            `json:"depth"`
+	Buckets []int          `json:"buckets"`
+	Entries []ReplicaEntry `json:"entries"`
+}
+
+type ReplicaDiffResponse struct {
+	Entries []ReplicaEntry `json:"entries"`
+}
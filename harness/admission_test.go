@@ -0,0 +1,60 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"main/data"
+	"main/server"
+)
+
+func TestTraceAndScanAdmitted(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Placement = server.PlacementOrdered
+	opts.Admission = func() *server.Admission { return server.NewAdmission(16, 16, 1, 3, server.DefaultMaxHops) }
+	c := newCluster(t, 2, opts)
+	entry, owner := c.Nodes[0], c.Nodes[1]
+
+	// Keys are placed by their leading bytes, so one beginning past ASCII falls in the upper half
+	key := "über"
+	if c.Owner(key) != owner {
+		t.Fatalf("%s is not owned by %s", key, owner.ID)
+	}
+	trace := func(node *Node) int {
+		body, _ := json.Marshal(&data.DataRequest{Key: key})
+		resp, err := c.Client.Post(node.URL("/trace"), "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	scan := func(node *Node) int {
+		resp, err := c.Client.Get(node.URL("/scan?prefix=k"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Spend the owner's bucket for our IP, forwarded traces draw on its peer budget instead
+	for i := 0; i < 3; i++ {
+		if status := scan(owner); status != http.StatusOK {
+			t.Fatalf("Scan %d within the burst was answered with %d", i, status)
+		}
+	}
+	if status := scan(owner); status != http.StatusTooManyRequests {
+		t.Fatalf("Scan beyond the burst was answered with %d", status)
+	}
+	if status := trace(owner); status != http.StatusTooManyRequests {
+		t.Fatalf("Trace beyond the burst was answered with %d", status)
+	}
+	for i := 0; i < 3; i++ {
+		if status := trace(entry); status != http.StatusOK {
+			t.Fatalf("Trace %d forwarded to a limited owner was answered with %d", i, status)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package harness
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorded - A request a server sent, with the status it was answered with
+type recorded struct {
+	method string
+	url    string
+	header http.Header
+	body   []byte
+	status int
+}
+
+// recorder - A transport keeping every request sent to paths ending in suffix
+type recorder struct {
+	suffix string
+
+	mu   sync.Mutex
+	sent []recorded
+}
+
+func (rec *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, rec.suffix) {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.sent = append(rec.sent, recorded{
+		method: req.Method,
+		url:    req.URL.String(),
+		header: req.Header.Clone(),
+		body:   body,
+		status: resp.StatusCode,
+	})
+	return resp, nil
+}
+
+func TestReplayedSignedRequestRejected(t *testing.T) {
+	rec := &recorder{suffix: "/neighbors/refresh"}
+	opts := DefaultOptions()
+	opts.Secret = []byte("cluster-secret")
+	opts.Transport = func() http.RoundTripper { return rec }
+	c := newCluster(t, 2, opts)
+
+	c.Refresh()
+	rec.mu.Lock()
+	sent := append([]recorded(nil), rec.sent...)
+	rec.mu.Unlock()
+	if len(sent) == 0 {
+		t.Fatal("No signed refresh was sent")
+	}
+
+	for _, msg := range sent {
+		if msg.status != http.StatusOK {
+			t.Fatalf("Signed refresh was answered with %d", msg.status)
+		}
+		req, _ := http.NewRequest(msg.method, msg.url, bytes.NewReader(msg.body))
+		req.Header = msg.header.Clone()
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Replayed refresh was answered with %s", resp.Status)
+		}
+	}
+}
+
+func TestSignedRequestReplayedToOtherNodeRejected(t *testing.T) {
+	rec := &recorder{suffix: "/neighbors/refresh"}
+	opts := DefaultOptions()
+	opts.Secret = []byte("cluster-secret")
+	opts.Transport = func() http.RoundTripper { return rec }
+	c := newCluster(t, 3, opts)
+
+	c.Refresh()
+	rec.mu.Lock()
+	sent := append([]recorded(nil), rec.sent...)
+	rec.mu.Unlock()
+	if len(sent) == 0 {
+		t.Fatal("No signed refresh was sent")
+	}
+
+	// Each node has yet to see the nonce, only the destination in the signature keeps it out
+	for _, msg := range sent {
+		dest, _ := url.Parse(msg.url)
+		for _, node := range c.Nodes {
+			if node.Address() == dest.Host {
+				continue
+			}
+			req, _ := http.NewRequest(msg.method, node.URL(dest.RequestURI()), bytes.NewReader(msg.body))
+			req.Header = msg.header.Clone()
+			resp, err := c.Client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("Refresh for %s replayed to %s was answered with %s", dest.Host, node.ID, resp.Status)
+			}
+		}
+	}
+}
+
+func TestUnsignedVerifyRejected(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Secret = []byte("cluster-secret")
+	c := newCluster(t, 2, opts)
+
+	resp, err := c.Client.Get(c.Nodes[0].URL("/admin/verify"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unsigned verify was answered with %s", resp.Status)
+	}
+}
@@ -0,0 +1,44 @@
+package harness
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"main/server"
+)
+
+func TestCachedReadExpiresWithRecord(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Cache = func() *server.ReadCache { return server.NewReadCache(16, time.Minute) }
+	c := newCluster(t, 2, opts)
+
+	// Read through the server which does not own the key, so it caches what the owner returns
+	key := JoinKey(0)
+	owner := c.Owner(key)
+	entry := c.Nodes[0]
+	if entry == owner {
+		entry = c.Nodes[1]
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, owner.URL("/data/"+url.PathEscape(key)+"?ttl=1"), strings.NewReader("v"))
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Write with a TTL was answered with %s", resp.Status)
+	}
+
+	if _, err := c.GetVia(entry, key); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if dRes, err := c.GetVia(entry, key); err == nil {
+		t.Fatalf("Read after the record expired returned %q from the cache", dRes.Data)
+	}
+}
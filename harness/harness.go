@@ -0,0 +1,364 @@
+// Package harness runs a whole CAN in one process for integration tests, with every server on a
+// local httptest listener so no network access is needed.
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"main/data"
+	"main/server"
+)
+
+// Options - Settings of the CAN a cluster creates
+type Options struct {
+	Dimension   int
+	Redundancy  int
+	Hasher      string
+	Placement   string
+	Torus       bool
+	Split       string
+	Secret      []byte
+	Reap        time.Duration // Interval between evictions of expired data, no reaper when zero
+	Refresh     time.Duration // Interval between updates sent to neighbors, none when zero
+	AntiEntropy time.Duration // Interval between replica exchanges, none when zero
+	JoinTimeout time.Duration // Time a joiner has to commit, server.DefaultJoinTimeout when zero
+
+	// Creates the limits on data requests of each server, none when nil
+	Admission func() *server.Admission
+
+	// Creates the read cache of each server, none when nil
+	Cache func() *server.ReadCache
+
+	// Creates the transport each server sends requests to other servers over, the default when nil
+	Transport func() http.RoundTripper
+}
+
+// DefaultOptions - The settings a server uses when started without any
+func DefaultOptions() Options {
+	return Options{
+		Dimension:  2,
+		Redundancy: 1,
+		Hasher:     server.DefaultHasher,
+		Placement:  server.PlacementHashed,
+		Split:      server.DefaultSplit,
+	}
+}
+
+// Node - One server of a cluster
+type Node struct {
+	ID     string
+	Server *server.Server
+	HTTP   *httptest.Server
+	Alive  bool
+
+	stop chan struct{}
+}
+
+// Address - The host:port the node listens on
+func (n *Node) Address() string {
+	return n.Server.Advertise.String()
+}
+
+// URL - Build the URL of a path on the node
+func (n *Node) URL(path string) string {
+	return n.Server.Advertise.URL(path)
+}
+
+// Cluster - Servers which have joined into a single CAN
+//
+// Nodes may be added and killed from several goroutines at once, Nodes itself is only safe to read once
+// they are done.
+type Cluster struct {
+	Nodes  []*Node
+	Client *http.Client
+	opts   Options
+
+	mu   sync.Mutex // Guards Nodes, next and each node's Alive
+	next int        // Index of the next node to start, nodes joining at once may finish out of order
+}
+
+// NodeID - The ID given to the i-th node of a cluster
+func NodeID(i int) string {
+	return fmt.Sprintf("node-%02d", i)
+}
+
+// JoinKey - The key the i-th node of a cluster joins with, so every run splits the space the same way
+func JoinKey(i int) string {
+	return fmt.Sprintf("join-%02d", i)
+}
+
+// NewCluster - Start n servers, the first creating the CAN and the rest joining it one at a time
+func NewCluster(n int, opts Options) (*Cluster, error) {
+	if n < 1 {
+		return nil, errors.New("A cluster needs at least one node")
+	}
+
+	c := &Cluster{
+		Client: &http.Client{Timeout: 10 * time.Second},
+		opts:   opts,
+	}
+	for i := 0; i < n; i++ {
+		if _, err := c.AddNode(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// AddNode - Start another server and join it to the CAN through the first live node
+func (c *Cluster) AddNode() (*Node, error) {
+	c.mu.Lock()
+	i := c.next
+	c.next++
+	c.mu.Unlock()
+
+	hasher, err := server.GetHasher(c.opts.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.CheckPlacement(c.opts.Placement); err != nil {
+		return nil, err
+	}
+	if err := server.CheckSplit(c.opts.Split); err != nil {
+		return nil, err
+	}
+
+	serv := server.CreateServer(c.opts.Dimension, c.opts.Redundancy, hasher, c.opts.Placement, "")
+	serv.Reg.Torus = c.opts.Torus
+	serv.Reg.SplitStrategy = c.opts.Split
+	serv.ID = NodeID(i)
+	serv.Secret = c.opts.Secret
+	if c.opts.Admission != nil {
+		serv.Admission = c.opts.Admission()
+	}
+	if c.opts.Cache != nil {
+		serv.Cache = c.opts.Cache()
+	}
+	if c.opts.Transport != nil {
+		serv.C = &http.Client{Transport: c.opts.Transport()}
+	}
+	if c.opts.JoinTimeout > 0 {
+		serv.JoinTimeout = c.opts.JoinTimeout
+	}
+
+	// The listener exists before the server starts, so we know the address to advertise
+	hts := httptest.NewUnstartedServer(server.NewRouter(serv))
+	serv.Advertise = server.ParseHost(hts.Listener.Addr().String(), "")
+	serv.Port = serv.Advertise.Port
+	hts.Start()
+
+	node := &Node{
+		ID:     serv.ID,
+		Server: serv,
+		HTTP:   hts,
+		Alive:  true,
+		stop:   make(chan struct{}),
+	}
+
+	if seed := c.Live(); seed != nil {
+		if err := serv.SendJoin(seed.Address(), JoinKey(i)); err != nil {
+			hts.Close()
+			return nil, err
+		}
+	} else {
+		serv.SetState(server.StateReady)
+	}
+	if c.opts.Reap > 0 {
+		serv.StartReaper(c.opts.Reap, node.stop)
+	}
+	if c.opts.Refresh > 0 {
+		serv.StartRefresh(c.opts.Refresh, node.stop)
+	}
+	if c.opts.AntiEntropy > 0 {
+		serv.StartAntiEntropy(c.opts.AntiEntropy, node.stop)
+	}
+
+	c.mu.Lock()
+	c.Nodes = append(c.Nodes, node)
+	c.mu.Unlock()
+	return node, nil
+}
+
+// nodes - Every node started so far, safe to range over while other nodes are added
+func (c *Cluster) nodes() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Node(nil), c.Nodes...)
+}
+
+// alive - Determine if a node has not been killed
+func (c *Cluster) alive(node *Node) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return node.Alive
+}
+
+// Live - The first node which has not been killed, nil if there are none
+func (c *Cluster) Live() *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, node := range c.Nodes {
+		if node.Alive {
+			return node
+		}
+	}
+	return nil
+}
+
+// Node - Find a node by its ID
+func (c *Cluster) Node(id string) *Node {
+	for _, node := range c.nodes() {
+		if node.ID == id {
+			return node
+		}
+	}
+	return nil
+}
+
+// Kill - Stop the i-th node without telling the rest of the CAN
+func (c *Cluster) Kill(i int) {
+	c.mu.Lock()
+	node := c.Nodes[i]
+	if !node.Alive {
+		c.mu.Unlock()
+		return
+	}
+	node.Alive = false
+	c.mu.Unlock()
+
+	close(node.stop)
+	node.HTTP.CloseClientConnections()
+	node.HTTP.Close()
+}
+
+// Refresh - Have every live node send one round of updates to its neighbors
+func (c *Cluster) Refresh() {
+	for _, node := range c.nodes() {
+		if c.alive(node) {
+			node.Server.Refresh()
+		}
+	}
+}
+
+// AntiEntropy - Have every live node run one round of replica exchanges with its partners
+func (c *Cluster) AntiEntropy() {
+	for _, node := range c.nodes() {
+		if c.alive(node) {
+			node.Server.AntiEntropy()
+		}
+	}
+}
+
+// Close - Stop every node
+func (c *Cluster) Close() {
+	for i := range c.nodes() {
+		c.Kill(i)
+	}
+}
+
+// Put - Store a value under key through the first live node
+func (c *Cluster) Put(key, value string) (*data.DataResponse, error) {
+	return c.PutVia(c.Live(), key, value)
+}
+
+// PutVia - Store a value under key through a given node
+func (c *Cluster) PutVia(node *Node, key, value string) (*data.DataResponse, error) {
+	if node == nil {
+		return nil, errors.New("No live node to send the request to")
+	}
+
+	body, _ := json.Marshal(&data.DataRequest{Key: key, Data: value, Upsert: true})
+	req, _ := http.NewRequest(http.MethodPut, node.URL("/data"), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	dRes := &data.DataResponse{}
+	if err := c.do(req, dRes); err != nil {
+		return nil, err
+	}
+	return dRes, nil
+}
+
+// Get - Retrieve the value stored under key through the first live node
+func (c *Cluster) Get(key string) (*data.DataResponse, error) {
+	return c.GetVia(c.Live(), key)
+}
+
+// GetVia - Retrieve the value stored under key through a given node
+func (c *Cluster) GetVia(node *Node, key string) (*data.DataResponse, error) {
+	if node == nil {
+		return nil, errors.New("No live node to send the request to")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, node.URL("/data/"+url.PathEscape(key)), nil)
+	req.Header.Set("Accept", "application/json")
+
+	dRes := &data.DataResponse{}
+	if err := c.do(req, dRes); err != nil {
+		return nil, err
+	}
+	return dRes, nil
+}
+
+// Owner - The live node whose region holds key
+func (c *Cluster) Owner(key string) *Node {
+	for _, node := range c.nodes() {
+		if !c.alive(node) {
+			continue
+		}
+		reg := node.Server.Reg
+		if reg.Owns(reg.HashKey(key)) {
+			return node
+		}
+	}
+	return nil
+}
+
+// Topology - Crawl the CAN from the first live node over /debug, returning every server reached by ID
+//
+// Neighbors which cannot be reached are left out.
+func (c *Cluster) Topology() (map[string]*data.DebugResponse, error) {
+	start := c.Live()
+	if start == nil {
+		return nil, errors.New("No live node to crawl from")
+	}
+
+	found, _ := server.Crawl(c.Client, start.Server.Advertise)
+	return found, nil
+}
+
+// Verify - Crawl the CAN from the first live node and check its invariants
+func (c *Cluster) Verify() (*data.VerifyResponse, error) {
+	start := c.Live()
+	if start == nil {
+		return nil, errors.New("No live node to crawl from")
+	}
+
+	return server.VerifyFrom(c.Client, start.Server.Advertise), nil
+}
+
+// do - Send a request and decode its JSON response into v, failing on any status other than 200
+func (c *Cluster) do(req *http.Request, v interface{}) error {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		eRes := data.ErrorResponse{}
+		json.Unmarshal(body, &eRes)
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, eRes.Message)
+	}
+	return json.Unmarshal(body, v)
+}
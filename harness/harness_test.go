@@ -0,0 +1,109 @@
+package harness
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"main/server"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestMain - Keep server logs to warnings, so the locks logging takes do not hide races from the race detector
+func TestMain(m *testing.M) {
+	server.SetLogLevel(logrus.WarnLevel)
+	os.Exit(m.Run())
+}
+
+// newCluster - Start a cluster, failing the test if it cannot be built, and close it when the test ends
+func newCluster(t *testing.T, n int, opts Options) *Cluster {
+	t.Helper()
+	c, err := NewCluster(n, opts)
+	if err != nil {
+		t.Fatalf("Could not start a cluster of %d nodes: %v", n, err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// verify - Fail the test if the CAN breaks any invariant
+func verify(t *testing.T, c *Cluster, nodes int) {
+	t.Helper()
+	vRes, err := c.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vRes.OK || vRes.Nodes != nodes {
+		t.Fatalf("Verify reached %d of %d nodes, ok %v, violations %+v", vRes.Nodes, nodes, vRes.OK, vRes.Violations)
+	}
+}
+
+func TestPutGetVerify(t *testing.T) {
+	c := newCluster(t, 6, DefaultOptions())
+	verify(t, c, 6)
+
+	for k := 0; k < 40; k++ {
+		key := fmt.Sprintf("key-%02d", k)
+		if _, err := c.PutVia(c.Nodes[k%len(c.Nodes)], key, "value-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for k := 0; k < 40; k++ {
+		key := fmt.Sprintf("key-%02d", k)
+		dRes, err := c.GetVia(c.Nodes[(k+3)%len(c.Nodes)], key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dRes.Data != "value-"+key {
+			t.Fatalf("Got %q for %s, want %q", dRes.Data, key, "value-"+key)
+		}
+		if owner := c.Owner(key); owner == nil {
+			t.Fatalf("No node owns %s", key)
+		}
+	}
+	verify(t, c, 6)
+}
+
+func TestConcurrentAddNode(t *testing.T) {
+	c := newCluster(t, 1, DefaultOptions())
+	for k := 0; k < 20; k++ {
+		if _, err := c.Put(JoinKey(k), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.AddNode(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	// Writes keep arriving at the seed while it hands out zones
+	for k := 0; k < 20; k++ {
+		c.PutVia(c.Live(), JoinKey(k), "w")
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Joiners may copy a neighbor's zone from just before it split, which refreshes repair
+	c.Refresh()
+	c.Refresh()
+	verify(t, c, 9)
+	for k := 0; k < 20; k++ {
+		if _, err := c.Get(JoinKey(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
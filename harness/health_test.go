@@ -0,0 +1,124 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"main/data"
+	"main/server"
+)
+
+// startServer - Serve a server which has not joined any CAN on a local listener, closed when the test ends
+func startServer(t *testing.T, id string) *server.Server {
+	t.Helper()
+	hasher, _ := server.GetHasher(server.DefaultHasher)
+	serv := server.CreateServer(2, 1, hasher, server.PlacementHashed, "")
+	serv.ID = id
+
+	hts := httptest.NewUnstartedServer(server.NewRouter(serv))
+	serv.Advertise = server.ParseHost(hts.Listener.Addr().String(), "")
+	serv.Port = serv.Advertise.Port
+	hts.Start()
+	t.Cleanup(hts.Close)
+	return serv
+}
+
+func TestJoinRefusedUntilReady(t *testing.T) {
+	serv := startServer(t, "starting")
+	manifest := serv.Manifest()
+
+	for _, state := range []string{server.StateStarting, server.StateJoining, server.StateLeaving} {
+		serv.SetState(state)
+		body, _ := json.Marshal(&data.JoinRequest{Key: JoinKey(1), ID: "joiner", Address: "127.0.0.1:1", Manifest: &manifest})
+		resp, err := http.Post(serv.Advertise.URL("/join"), "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+			t.Fatalf("Join at a %s server was answered with %s", state, resp.Status)
+		}
+	}
+	if geo := serv.Reg.Geometry(); geo.Zone != "" || geo.Epoch != 0 {
+		t.Fatalf("A server which never joined split its zone into %q", geo.Zone)
+	}
+}
+
+func TestDebugDuringJoin(t *testing.T) {
+	c := newCluster(t, 1, DefaultOptions())
+	for k := 0; k < 20; k++ {
+		if _, err := c.Put(JoinKey(k), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	joiner := startServer(t, NodeID(1))
+
+	// Probes read the joiner's region while it takes up its zone
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, path := range []string{"/debug", "/healthz", "/data/" + JoinKey(3)} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if resp, err := http.Get(joiner.Advertise.URL(path)); err == nil {
+					resp.Body.Close()
+				}
+			}
+		}(path)
+	}
+
+	err := joiner.SendJoin(c.Nodes[0].Address(), JoinKey(1))
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := joiner.State(); state != server.StateReady {
+		t.Fatalf("Joiner is %s after joining, want %s", state, server.StateReady)
+	}
+}
+
+func TestMalformedRangesRefused(t *testing.T) {
+	serv := startServer(t, "peer")
+	serv.SetState(server.StateReady)
+	short := data.RangeResponse{P1: data.PointResponse{Coords: []float64{0}}, P2: data.PointResponse{Coords: []float64{1}}}
+	whole := data.RangeResponse{P1: data.PointResponse{Coords: []float64{0, 0}}, P2: data.PointResponse{Coords: []float64{1, 1}}}
+
+	requests := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPut, "/neighbors", &data.NeighborRequest{ID: "other", Address: "127.0.0.1:1", Range: short}},
+		{http.MethodPatch, "/neighbors", &data.NeighborRequest{ID: "other", Address: "127.0.0.1:1", Range: short}},
+		{http.MethodPost, "/neighbors/refresh", &data.RefreshRequest{ID: "other", Address: "127.0.0.1:1", Range: short}},
+		{http.MethodPost, "/neighbors/refresh", &data.RefreshRequest{ID: "other", Address: "127.0.0.1:1", Range: whole,
+			Neighbors: []data.NeighborResponse{{ID: "third", Address: "127.0.0.1:2", Range: short}}}},
+		{http.MethodPost, "/replicas/tree", &data.MerkleRequest{Owner: "other", Range: short, Depth: 4}},
+		{http.MethodPost, "/replicas/diff", &data.ReplicaDiffRequest{Owner: "other", Range: short, Depth: 4, Buckets: []int{0}}},
+	}
+	for _, req := range requests {
+		body, _ := json.Marshal(req.body)
+		r, _ := http.NewRequest(req.method, serv.Advertise.URL(req.path), bytes.NewReader(body))
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s %s with a range of one coordinate was answered with %s", req.method, req.path, resp.Status)
+		}
+	}
+	if neighbors := serv.Reg.GetNeighborResponse(); len(neighbors) != 0 {
+		t.Fatalf("Malformed neighbors were added: %v", neighbors)
+	}
+}
@@ -0,0 +1,172 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"main/data"
+	"main/server"
+)
+
+// testJoinTimeout - Join timeout of clusters whose joins are expected to roll back, short to keep tests quick
+const testJoinTimeout = 300 * time.Millisecond
+
+// commitDropper - A transport losing join commits, before they are sent until the time in dropUntil, or the
+// first answer once they were applied when lose is set
+type commitDropper struct {
+	dropUntil *int64 // Unix nanoseconds
+	lose      *int32
+}
+
+func (d *commitDropper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, "/join/commit") {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	if time.Now().UnixNano() < atomic.LoadInt64(d.dropUntil) {
+		return nil, errors.New("commit dropped")
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && atomic.CompareAndSwapInt32(d.lose, 1, 0) {
+		resp.Body.Close()
+		return nil, errors.New("commit answer lost")
+	}
+	return resp, err
+}
+
+func TestJoinRollbackOnTimeout(t *testing.T) {
+	opts := DefaultOptions()
+	opts.JoinTimeout = testJoinTimeout
+	c := newCluster(t, 1, opts)
+	seed := c.Nodes[0].Server
+	before := seed.Reg.Geometry()
+
+	// Ask for a zone and never commit
+	manifest := seed.Manifest()
+	body, _ := json.Marshal(&data.JoinRequest{Key: JoinKey(1), ID: "silent", Address: "127.0.0.1:1", Manifest: &manifest})
+	resp, err := c.Client.Post(c.Nodes[0].URL("/join"), "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Join was answered with %s", resp.Status)
+	}
+	if state := seed.State(); state != server.StateHandoff {
+		t.Fatalf("Seed is %s while a join is pending, want %s", state, server.StateHandoff)
+	}
+
+	time.Sleep(2 * testJoinTimeout)
+	if state := seed.State(); state != server.StateReady {
+		t.Fatalf("Seed is %s after the join timed out, want %s", state, server.StateReady)
+	}
+	if after := seed.Reg.Geometry(); !after.Space.Equal(&before.Space) || after.Zone != before.Zone {
+		t.Fatalf("Seed zone changed from %v to %v although the join was never committed", before.Space, after.Space)
+	}
+
+	// The released slot lets the next join through
+	if _, err := c.AddNode(); err != nil {
+		t.Fatal(err)
+	}
+	verify(t, c, 2)
+}
+
+func TestJoinRollbackOnLostCommit(t *testing.T) {
+	var dropUntil int64
+	var lose int32
+	opts := DefaultOptions()
+	opts.JoinTimeout = testJoinTimeout
+	opts.Transport = func() http.RoundTripper { return &commitDropper{dropUntil: &dropUntil, lose: &lose} }
+	c := newCluster(t, 1, opts)
+	for k := 0; k < 30; k++ {
+		if _, err := c.Put(JoinKey(k), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seed := c.Nodes[0].Server
+	before := seed.Reg.Geometry()
+
+	// Commits which do not arrive before the seed rolls back leave the joiner retrying until it is refused
+	atomic.StoreInt64(&dropUntil, time.Now().Add(2*testJoinTimeout).UnixNano())
+	if _, err := c.AddNode(); err == nil {
+		t.Fatal("Join succeeded although its commit never reached the owner")
+	}
+	if after := seed.Reg.Geometry(); !after.Space.Equal(&before.Space) {
+		t.Fatalf("Seed zone changed from %v to %v although the join was rolled back", before.Space, after.Space)
+	}
+	verify(t, c, 1)
+
+	// A commit applied whose answer is lost is acknowledged when retried
+	atomic.StoreInt32(&lose, 1)
+	if _, err := c.AddNode(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&lose) != 0 {
+		t.Fatal("The commit answer was never lost")
+	}
+	verify(t, c, 2)
+	for k := 0; k < 30; k++ {
+		if _, err := c.Get(JoinKey(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJoinGivesUpOnSilentOwner(t *testing.T) {
+	opts := DefaultOptions()
+	opts.JoinTimeout = testJoinTimeout
+	c := newCluster(t, 1, opts)
+
+	// No commit ever reaches the owner, as if it died once it reserved the split
+	dropUntil := time.Now().Add(time.Hour).UnixNano()
+	var lose int32
+	joiner := startServer(t, NodeID(1))
+	joiner.JoinTimeout = testJoinTimeout
+	joiner.C = &http.Client{Transport: &commitDropper{dropUntil: &dropUntil, lose: &lose}}
+
+	start := time.Now()
+	if err := joiner.SendJoin(c.Nodes[0].Address(), JoinKey(1)); err == nil {
+		t.Fatal("Join succeeded although its commit never reached the owner")
+	}
+	if took := time.Since(start); took > 10*testJoinTimeout {
+		t.Fatalf("Joiner retried its commit for %v", took)
+	}
+	if state := joiner.State(); state != server.StateStarting {
+		t.Fatalf("Joiner is %s after giving up, want %s", state, server.StateStarting)
+	}
+	if geo := joiner.Reg.Geometry(); geo.Zone != "" {
+		t.Fatalf("Joiner kept zone %q after giving up", geo.Zone)
+	}
+
+	// The joiner gave back its join slot, so it can join once commits get through
+	atomic.StoreInt64(&dropUntil, 0)
+	if err := joiner.SendJoin(c.Nodes[0].Address(), JoinKey(1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoinerAdoptsHasher(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Hasher = server.HasherFNV
+	c := newCluster(t, 1, opts)
+
+	// startServer uses the default hasher, which the CAN was not created with
+	joiner := startServer(t, NodeID(1))
+	if err := joiner.SendJoin(c.Nodes[0].Address(), JoinKey(1)); err == nil {
+		t.Fatal("Joiner with a different hasher was accepted")
+	}
+	if err := joiner.AdoptHasher(c.Nodes[0].Address()); err != nil {
+		t.Fatal(err)
+	}
+	if name := joiner.Reg.Hasher.Name(); name != server.HasherFNV {
+		t.Fatalf("Joiner took the hasher %s, want %s", name, server.HasherFNV)
+	}
+	if err := joiner.SendJoin(c.Nodes[0].Address(), JoinKey(1)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"main/data"
+	"main/server"
+)
+
+func TestReplicaRangeOutsideOwnerZoneRefused(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Redundancy = 2
+	c := newCluster(t, 2, opts)
+	owner, partner := c.Nodes[0], c.Nodes[1]
+	for k := 0; k < 20; k++ {
+		if _, err := c.Put(JoinKey(k), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	whole := data.RangeResponse{P1: data.PointResponse{Coords: []float64{0, 0}}, P2: data.PointResponse{Coords: []float64{1, 1}}}
+	zone := owner.Server.Reg.Geometry().Space
+	ranges := []struct {
+		owner  string
+		rng    data.RangeResponse
+		status int
+	}{
+		{owner.ID, *zone.GetRangeResponse(), http.StatusOK},
+		{owner.ID, whole, http.StatusBadRequest},
+		{"stranger", *zone.GetRangeResponse(), http.StatusBadRequest},
+	}
+	for _, tt := range ranges {
+		body, _ := json.Marshal(&data.MerkleRequest{Owner: tt.owner, Range: tt.rng, Depth: 4})
+		resp, err := http.Post(partner.URL("/replicas/tree"), "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Fatalf("Tree of %s over %v was answered with %s, want %d", tt.owner, tt.rng, resp.Status, tt.status)
+		}
+	}
+
+	if _, _, err := owner.Server.SyncReplica(server.Neighbor{ID: partner.ID, Host: partner.Server.Advertise}); err != nil {
+		t.Fatal(err)
+	}
+}
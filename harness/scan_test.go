@@ -0,0 +1,119 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"main/data"
+	"main/server"
+)
+
+func TestOrderedScanPaging(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Placement = server.PlacementOrdered
+	c := newCluster(t, 6, opts)
+
+	want := []string{}
+	for k := 0; k < 50; k++ {
+		key := fmt.Sprintf("k%03d", k)
+		want = append(want, key)
+		if _, err := c.Put(key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Put("other", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > len(want) {
+			t.Fatal("Scan never ran out of pages")
+		}
+		query := url.Values{"prefix": {"k"}, "limit": {"7"}, "cursor": {cursor}}
+		node := c.Nodes[page%len(c.Nodes)]
+		resp, err := c.Client.Get(node.URL("/scan?" + query.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sRes := data.ScanResponse{}
+		json.NewDecoder(resp.Body).Decode(&sRes)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Scan page %d was answered with %s", page, resp.Status)
+		}
+		if len(sRes.Items) > 7 {
+			t.Fatalf("Scan page %d holds %d items over its limit", page, len(sRes.Items))
+		}
+		for _, item := range sRes.Items {
+			got = append(got, item.Key)
+		}
+		if sRes.Cursor == "" {
+			break
+		}
+		cursor = sRes.Cursor
+	}
+
+	if !sort.StringsAreSorted(got) || strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("Scan returned %v, want %v", got, want)
+	}
+}
+
+func TestScanZonesLimited(t *testing.T) {
+	rec := &recorder{suffix: "/scan/zone"}
+	opts := DefaultOptions()
+	opts.Placement = server.PlacementOrdered
+	opts.Transport = func() http.RoundTripper { return rec }
+	c := newCluster(t, 6, opts)
+
+	for k := 0; k < 50; k++ {
+		if _, err := c.Put(fmt.Sprintf("k%03d", k), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, node := range c.Nodes {
+		resp, err := c.Client.Get(node.URL("/scan/zone?prefix=k&limit=3"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zRes := data.ZoneScanResponse{}
+		json.NewDecoder(resp.Body).Decode(&zRes)
+		resp.Body.Close()
+		if len(zRes.Items) > 3 {
+			t.Fatalf("Zone scan at %s returned %d items over its limit", node.ID, len(zRes.Items))
+		}
+	}
+
+	resp, err := c.Client.Get(c.Nodes[0].URL("/scan?prefix=k&limit=5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sRes := data.ScanResponse{}
+	json.NewDecoder(resp.Body).Decode(&sRes)
+	resp.Body.Close()
+	got := []string{}
+	for _, item := range sRes.Items {
+		got = append(got, item.Key)
+	}
+	if strings.Join(got, ",") != "k000,k001,k002,k003,k004" || sRes.Cursor != "k004" {
+		t.Fatalf("Scan returned %v with cursor %q", got, sRes.Cursor)
+	}
+
+	// Each zone is only asked for the keys the page still lacks
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, msg := range rec.sent {
+		u, _ := url.Parse(msg.url)
+		if limit, err := strconv.Atoi(u.Query().Get("limit")); err != nil || limit < 1 || limit > 5 {
+			t.Fatalf("Zone was asked for %q keys by a scan of 5", u.Query().Get("limit"))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package harness
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"main/server"
+)
+
+func TestIfMatchPreconditionFailed(t *testing.T) {
+	c := newCluster(t, 4, DefaultOptions())
+	key := JoinKey(7)
+	dRes, err := c.Put(key, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Through the owner and through a node forwarding the write alike
+	for _, node := range c.Nodes {
+		req, _ := http.NewRequest(http.MethodPut, node.URL("/data/"+key+"?upsert=true"), strings.NewReader("stale"))
+		req.Header.Set("If-Match", server.ETag(dRes.Version+1))
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Fatalf("Write through %s with a stale version was answered with %s", node.ID, resp.Status)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, c.Nodes[0].URL("/data/"+key+"?upsert=true"), strings.NewReader("second"))
+	req.Header.Set("If-Match", server.ETag(dRes.Version))
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Write with the current version was answered with %s", resp.Status)
+	}
+}
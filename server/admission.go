@@ -0,0 +1,369 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"main/data"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Headers exchanged between servers about forwarded data requests
+const (
+	forwardedHeader = "X-Gocan-Forwarded" // Node ID of the server which forwarded a request
+	hopsHeader      = "X-Gocan-Hops"      // Number of times a request has been forwarded
+	rejectedHeader  = "X-Gocan-Rejected"  // Node ID of the server which refused a request for lack of capacity
+)
+
+// DefaultMaxHops - Times a data request may be forwarded before it is refused, as greedy routing does not
+// always reach a key's owner
+const DefaultMaxHops = 128
+
+// contextKey - Keys of values middleware attaches to a request
+type contextKey int
+
+// Values attached to a request by middleware
+const (
+	socketAddrKey contextKey = iota // The address a request's connection came from, kept by keepSocketAddr
+	hopsKey                         // Times a request had been forwarded when it arrived, set by Admit
+)
+
+// maxReroutes - Other neighbors tried when the neighbor a request is forwarded to is overloaded
+const maxReroutes = 2
+
+// idleBuckets - Number of client buckets kept before full ones are dropped
+const idleBuckets = 4096
+
+// Admission - Limits on the data requests a server handles, a nil admission admits everything
+//
+// Clients and other servers forwarding requests draw on separate concurrency budgets, so a burst
+// forwarded by peers cannot starve local clients, and each client IP is rate limited on its own.
+type Admission struct {
+	inflight  chan struct{} // Client requests being handled, nil for no limit
+	forwarded chan struct{} // Forwarded requests being handled, nil for no limit
+	rate      float64       // Requests per second allowed from each client, zero for no limit
+	burst     float64       // Requests a client may send at once
+	maxHops   int           // Times a request may be forwarded before it is refused
+
+	mu      sync.Mutex
+	buckets map[string]*bucket // Keyed by client IP
+	stats   data.AdmissionStats
+}
+
+// bucket - Tokens left for one client, refilled at the admission's rate
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewAdmission - Create limits for a server, a zero limit is not enforced, a zero burst equals the rate and
+// zero hops means DefaultMaxHops
+func NewAdmission(inflight, forwarded, rate, burst, maxHops int) *Admission {
+	a := &Admission{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		maxHops: maxHops,
+		buckets: make(map[string]*bucket),
+	}
+	if a.maxHops <= 0 {
+		a.maxHops = DefaultMaxHops
+	}
+	if inflight > 0 {
+		a.inflight = make(chan struct{}, inflight)
+	}
+	if forwarded > 0 {
+		a.forwarded = make(chan struct{}, forwarded)
+	}
+	if a.burst <= 0 {
+		a.burst = a.rate
+	}
+	return a
+}
+
+// take - Spend one of a client's tokens, returning how long until one is available if none is left
+func (a *Admission) take(client string, now time.Time) (bool, time.Duration) {
+	if a.rate <= 0 {
+		return true, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	b, prs := a.buckets[client]
+	if !prs {
+		if len(a.buckets) >= idleBuckets {
+			a.dropFull(now)
+		}
+		b = &bucket{tokens: a.burst, last: now}
+		a.buckets[client] = b
+	}
+	b.tokens = math.Min(a.burst, b.tokens+now.Sub(b.last).Seconds()*a.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / a.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// dropFull - Forget clients whose buckets have refilled, they start full again anyway, the caller must hold the lock
+func (a *Admission) dropFull(now time.Time) {
+	for client, b := range a.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*a.rate >= a.burst {
+			delete(a.buckets, client)
+		}
+	}
+}
+
+// acquire - Take a slot from a concurrency budget without waiting, a nil budget always has room
+func acquire(budget chan struct{}) bool {
+	if budget == nil {
+		return true
+	}
+	select {
+	case budget <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release - Return a slot taken from a concurrency budget
+func release(budget chan struct{}) {
+	if budget != nil {
+		<-budget
+	}
+}
+
+// keepSocketAddr - Middleware remembering the address a request's connection came from, before
+// middleware.RealIP replaces it with whatever address the request's headers claim
+func keepSocketAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), socketAddrKey, r.RemoteAddr)))
+	})
+}
+
+// socketAddr - The address a request's connection came from, which a client cannot choose the way it
+// can choose X-Forwarded-For
+func socketAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(socketAddrKey).(string); ok {
+		return addr
+	}
+	return r.RemoteAddr
+}
+
+// forwardedByPeer - Determine if a request was forwarded by another server of the CAN and how many times
+// it has been forwarded, trusting its markers only when they are signed with the cluster secret or, in a
+// CAN without one, sent from the address of the neighbor they name
+//
+// Requests with markers which cannot be trusted are treated as coming straight from a client.
+func (s *Server) forwardedByPeer(r *http.Request) (int, bool, error) {
+	id := r.Header.Get(forwardedHeader)
+	if id == "" {
+		return 0, false, nil
+	}
+
+	hops, err := strconv.Atoi(r.Header.Get(hopsHeader))
+	if err != nil || hops < 1 {
+		err = errors.New("Invalid hop count " + strconv.Quote(r.Header.Get(hopsHeader)))
+	} else if len(s.Secret) > 0 {
+		var body []byte
+		if body, err = ioutil.ReadAll(r.Body); err == nil {
+			err = s.checkSignature(r.Header, r.Method, r.URL.RequestURI(), bodyHash(body), s.ID, id, strconv.Itoa(hops))
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	} else if !s.fromNeighbor(id, r) {
+		err = errors.New("Sender is not the neighbor " + id)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return hops, true, nil
+}
+
+// requestHops - Times a request had been forwarded when it reached this server
+func requestHops(r *http.Request) int {
+	hops, _ := r.Context().Value(hopsKey).(int)
+	return hops
+}
+
+// fromNeighbor - Determine if a request's connection came from the address of a neighbor
+func (s *Server) fromNeighbor(id string, r *http.Request) bool {
+	ip, _ := getHostFromRemoteAddr(socketAddr(r))
+	for _, neighbor := range s.Reg.NeighborList() {
+		if neighbor.ID == id {
+			return neighbor.IP == ip
+		}
+	}
+	return false
+}
+
+// Admit - Refuse data requests beyond the server's limits with 429 or 503 and Retry-After, and requests
+// forwarded too many times with 508
+func (s *Server) Admit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Until a server holds a zone of its own, it must not answer for the whole space it starts with
+		if state := s.State(); state == StateStarting || state == StateJoining {
+			s.reject(w, http.StatusServiceUnavailable, time.Second, stateMessages[state])
+			return
+		}
+
+		a := s.Admission
+		maxHops := DefaultMaxHops
+		if a != nil {
+			maxHops = a.maxHops
+		}
+
+		hops, forwarded, err := s.forwardedByPeer(r)
+		if err != nil {
+			log.Warn("Treating request marked as forwarded as a client request: " + err.Error())
+			if a != nil {
+				a.count(&a.stats.Untrusted)
+			}
+		}
+
+		// Greedy routing can pass a key back and forth between servers, so its hops are capped
+		if hops > maxHops {
+			if a != nil {
+				a.count(&a.stats.HopLimited)
+			}
+			s.reject(w, http.StatusLoopDetected, 0, "Request was forwarded "+strconv.Itoa(maxHops)+" times without reaching its owner")
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), hopsKey, hops))
+		if a == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Requests forwarded by other servers only draw on the peer budget, clients were limited where they entered
+		budget := a.inflight
+		if forwarded {
+			budget = a.forwarded
+		} else {
+			ip, _ := getHostFromRemoteAddr(socketAddr(r))
+			if ok, wait := a.take(ip, time.Now()); !ok {
+				a.count(&a.stats.Limited)
+				s.reject(w, http.StatusTooManyRequests, wait, "Too many requests from "+ip+", retry later")
+				return
+			}
+		}
+
+		if !acquire(budget) {
+			a.count(&a.stats.Overloaded)
+			s.reject(w, http.StatusServiceUnavailable, time.Second, "Server is overloaded, retry later")
+			return
+		}
+		defer release(budget)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// count - Add one to a counter of the admission's statistics
+func (a *Admission) count(counter *uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	*counter++
+}
+
+// Stats - Count the requests the admission refused or did not trust
+func (a *Admission) Stats() *data.AdmissionStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := a.stats
+	stats.MaxHops = a.maxHops
+	return &stats
+}
+
+// reject - Refuse a request, naming this server so forwarders can route around it, a zero wait sends no
+// Retry-After
+func (s *Server) reject(w http.ResponseWriter, status int, wait time.Duration, message string) {
+	log.WithFields(logrus.Fields{
+		"status": status,
+		"wait":   wait,
+	}).Warn(message)
+
+	w.Header().Set("Content-Type", "application/json")
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	w.Header().Set(rejectedHeader, s.ID)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&data.ErrorResponse{Message: message})
+}
+
+// overloaded - Determine if a neighbor itself refused a forwarded request for lack of capacity, rather
+// than passing back a refusal from further along the route
+func overloaded(resp *http.Response, neighbor *Neighbor) bool {
+	return (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) &&
+		resp.Header.Get(rejectedHeader) == neighbor.ID
+}
+
+// forward - Send a data request on toward the owner of a point, trying other neighbors closer to the
+// point when the chosen one is overloaded, and relay the response
+func (s *Server) forward(w http.ResponseWriter, r *http.Request, neighbor *Neighbor, pt Point, method, path string, body []byte, contentType string) {
+	if resp := s.sendForward(w, r, neighbor, pt, method, path, body, contentType); resp != nil {
+		relayResponse(w, resp)
+	}
+}
+
+// newForwardedRequest - Create the request passing a client's request on to a neighbor, marked as forwarded
+// by us with one more hop than it arrived with
+func (s *Server) newForwardedRequest(r *http.Request, neighbor *Neighbor, method, path string, body []byte) (*http.Request, error) {
+	// The markers are signed along with the request, so clients cannot claim the peer budget or reset the hops
+	hops := strconv.Itoa(requestHops(r) + 1)
+	req, err := s.newSignedRequest(neighbor.ID, method, neighbor.URL(path), body, s.ID, hops)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(forwardedHeader, s.ID)
+	req.Header.Set(hopsHeader, hops)
+	return req, nil
+}
+
+// sendForward - Send a data request on toward the owner of a point like forward, returning the response
+// for the caller to relay, or nil once a failure has been reported to the client
+func (s *Server) sendForward(w http.ResponseWriter, r *http.Request, neighbor *Neighbor, pt Point, method, path string, body []byte, contentType string) *http.Response {
+	tried := map[string]bool{}
+	for attempt := 0; ; attempt++ {
+		req, err := s.newForwardedRequest(r, neighbor, method, path, body)
+		if err != nil {
+			forwardFailed(w, err)
+			return nil
+		}
+		copyHeaders(req, r)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := s.C.Do(req)
+		if err != nil {
+			forwardFailed(w, err)
+			return nil
+		}
+
+		tried[neighbor.ID] = true
+		if attempt < maxReroutes && overloaded(resp, neighbor) {
+			if next := s.Reg.alternateNeighbor(pt, tried); next != nil {
+				log.WithFields(logrus.Fields{
+					"overloaded": neighbor.ID,
+					"next":       next.ID,
+				}).Info("Rerouting request around overloaded neighbor")
+				resp.Body.Close()
+				neighbor = next
+				continue
+			}
+		}
+		return resp
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"main/data"
+)
+
+// Headers carrying the signature of server-to-server messages
+const (
+	timestampHeader = "X-Gocan-Timestamp"
+	nonceHeader     = "X-Gocan-Nonce"
+	signatureHeader = "X-Gocan-Signature"
+)
+
+// signatureWindow - How far a message's timestamp may be from our clock before it is rejected
+const signatureWindow = 30 * time.Second
+
+// LoadSecret - Read the cluster secret from a flag value or a file, the file taking precedence
+func LoadSecret(value, file string) ([]byte, error) {
+	if file != "" {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		value = strings.TrimSpace(string(contents))
+		if value == "" {
+			return nil, errors.New("Secret file " + file + " is empty")
+		}
+	}
+	return []byte(value), nil
+}
+
+// nonceCache - Remembers recently seen nonces so signed messages cannot be replayed
+type nonceCache struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []seenNonce // Nonces in the order they were seen, oldest first
+}
+
+type seenNonce struct {
+	nonce string
+	at    time.Time
+}
+
+// add - Record a nonce, returning false if it has been seen within the signature window
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+
+	// Forget nonces old enough that their timestamps would be rejected anyway, only looking past the
+	// oldest ones
+	for len(c.order) > 0 && now.Sub(c.order[0].at) > 2*signatureWindow {
+		delete(c.seen, c.order[0].nonce)
+		c.order = c.order[1:]
+	}
+
+	if _, prs := c.seen[nonce]; prs {
+		return false
+	}
+	c.seen[nonce] = now
+	c.order = append(c.order, seenNonce{nonce: nonce, at: now})
+	return true
+}
+
+// sign - Compute the HMAC of a message's parts with the cluster secret
+func (s *Server) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// bodyHash - Hash a message body for inclusion in its signature
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// newNonce - Generate a random value identifying a single message
+func newNonce() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// setSignature - Stamp a message's headers with a timestamp, nonce and signature over its parts
+func (s *Server) setSignature(header http.Header, parts ...string) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := newNonce()
+	header.Set(timestampHeader, ts)
+	header.Set(nonceHeader, nonce)
+	header.Set(signatureHeader, s.sign(append(parts, ts, nonce)...))
+}
+
+// checkSignature - Verify a message's timestamp and signature over its parts, and that it is not a replay
+func (s *Server) checkSignature(header http.Header, parts ...string) error {
+	ts, nonce, sig := header.Get(timestampHeader), header.Get(nonceHeader), header.Get(signatureHeader)
+	if ts == "" || nonce == "" || sig == "" {
+		return errors.New("Message is not signed")
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("Invalid signature timestamp")
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(unix, 0)); skew > signatureWindow || skew < -signatureWindow {
+		return errors.New("Signature timestamp outside of allowed window")
+	}
+
+	expected := s.sign(append(parts, ts, nonce)...)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return errors.New("Invalid signature")
+	}
+
+	if !s.nonces.add(nonce, now) {
+		return errors.New("Message has already been received")
+	}
+	return nil
+}
+
+// newSignedRequest - Create a server-to-server request for the server with ID to, signing it along with any
+// extra parts when the cluster has a secret
+//
+// The signature covers the destination, so a captured request cannot be replayed against another server.
+func (s *Server) newSignedRequest(to, method, url string, body []byte, extra ...string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Secret) > 0 {
+		s.setSignature(req.Header, append([]string{method, req.URL.RequestURI(), bodyHash(body), to}, extra...)...)
+	}
+	return req, nil
+}
+
+// signResponse - Sign a response body before it is written, when the cluster has a secret
+func (s *Server) signResponse(w http.ResponseWriter, body []byte) {
+	if len(s.Secret) > 0 {
+		s.setSignature(w.Header(), "response", bodyHash(body))
+	}
+}
+
+// checkResponse - Verify the signature on a response body, when the cluster has a secret
+func (s *Server) checkResponse(resp *http.Response, body []byte) error {
+	if len(s.Secret) == 0 {
+		return nil
+	}
+	return s.checkSignature(resp.Header, "response", bodyHash(body))
+}
+
+// RequireSignature - Middleware rejecting requests without a valid signature when the cluster has a secret
+func (s *Server) RequireSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.Secret) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = s.checkSignature(r.Header, r.Method, r.URL.RequestURI(), bodyHash(body), s.ID)
+		}
+		if err != nil {
+			log.Warn("Rejected unauthenticated request to " + r.URL.Path + ": " + err.Error())
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"main/data"
+)
+
+// DefaultCacheTTL - Time a forwarded read stays cached when no TTL is chosen
+const DefaultCacheTTL = time.Second
+
+// idleWrites - Number of written keys remembered before all are forgotten at once
+const idleWrites = 4096
+
+// cacheHeader - Marks responses served from a forwarding server's read cache
+const cacheHeader = "X-Gocan-Cache"
+
+// ReadCache - Responses to reads this server forwarded, so hot keys are answered without travelling to
+// their owner, least recently used entries are evicted first
+//
+// Entries live for at most the TTL. Writes forwarded through this server drop the entry for their key,
+// writes taking other routes are only seen once the entry expires.
+//
+// Each write is numbered as it passes, and a read only caches its response if no write to its key has
+// passed since the read was forwarded, so a read overtaken by a write never brings back the old value.
+type ReadCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List               // Front is the most recently used
+	entries map[string]*list.Element // Keyed by cacheKey
+	hits    uint64
+	misses  uint64
+	writes  uint64            // Number of writes which have passed
+	written map[string]uint64 // Number of the last write to each key, forgotten once it holds too many keys
+	floor   uint64            // Reads forwarded before this write are not cached, as their keys' writes were forgotten
+}
+
+// cached - A relayed response held in the read cache
+type cached struct {
+	id      string
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// NewReadCache - Create a read cache holding up to size responses for a TTL, a zero TTL uses DefaultCacheTTL
+func NewReadCache(size int, ttl time.Duration) *ReadCache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &ReadCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		written: make(map[string]uint64),
+	}
+}
+
+// Mark - Number the point a read is forwarded at, for Put to tell if a write has passed since
+func (c *ReadCache) Mark() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes
+}
+
+// cacheKey - Identify a cached response, JSON and raw forms of a key are cached apart
+func cacheKey(key string, asJSON bool) string {
+	if asJSON {
+		return "json\x00" + key
+	}
+	return "raw\x00" + key
+}
+
+// Get - Find a live cached response for a key, counting the hit or miss
+func (c *ReadCache) Get(key string, asJSON bool, now time.Time) (*cached, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, prs := c.entries[cacheKey(key, asJSON)]
+	if prs && now.Before(el.Value.(*cached).expires) {
+		c.order.MoveToFront(el)
+		c.hits++
+		return el.Value.(*cached), true
+	}
+	if prs {
+		c.remove(el)
+	}
+	c.misses++
+	return nil, false
+}
+
+// Put - Cache a successful response to a read forwarded at mark, expiring with the record if it expires
+// sooner than the TTL, unless a write to the key has passed since
+func (c *ReadCache) Put(key string, asJSON bool, header http.Header, body []byte, now time.Time, mark uint64) {
+	expires := now.Add(c.ttl)
+	if at, err := http.ParseTime(header.Get("Expires")); err == nil && at.Before(expires) {
+		expires = at
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if mark < c.floor || c.written[key] > mark {
+		return
+	}
+
+	id := cacheKey(key, asJSON)
+	if el, prs := c.entries[id]; prs {
+		c.remove(el)
+	}
+	c.entries[id] = c.order.PushFront(&cached{id: id, header: header.Clone(), body: body, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Invalidate - Drop both forms of a key, as a write to it passes through, and keep reads already on their
+// way from caching the value it replaces
+func (c *ReadCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writes++
+	if len(c.written) >= idleWrites {
+		c.written = make(map[string]uint64)
+		c.floor = c.writes
+	}
+	c.written[key] = c.writes
+
+	for _, asJSON := range []bool{true, false} {
+		if el, prs := c.entries[cacheKey(key, asJSON)]; prs {
+			c.remove(el)
+		}
+	}
+}
+
+// remove - Drop an entry, the caller must hold the lock
+func (c *ReadCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cached).id)
+}
+
+// Stats - Count the cache's hits, misses and entries
+func (c *ReadCache) Stats() *data.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &data.CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: c.order.Len(),
+		Size:    c.size,
+	}
+}
+
+// invalidate - Drop cached reads of a key as a write to it is forwarded through this server, both before
+// the write is sent and once it is answered
+func (s *Server) invalidate(key string) {
+	if s.Cache != nil {
+		s.Cache.Invalidate(key)
+	}
+}
+
+// write - Answer a read from a cached response
+func (e *cached) write(w http.ResponseWriter) {
+	for name, vals := range e.header {
+		w.Header()[name] = vals
+	}
+	w.Header().Set(cacheHeader, "hit")
+	w.WriteHeader(http.StatusOK)
+	w.Write(e.body)
+}
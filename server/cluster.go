@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"main/data"
+)
+
+// Versions of the protocol servers speak with each other, raised whenever a message changes incompatibly
+const (
+	ProtocolVersion    = 1 // Version this server speaks
+	MinProtocolVersion = 1 // Oldest version this server still understands
+)
+
+// ErrIncompatible - A joiner's parameters or protocol do not match the CAN it is joining
+var ErrIncompatible = errors.New("Joiner is incompatible with this CAN")
+
+// Manifest - Describe the parameters every server of the CAN must share and the protocol versions
+// this server speaks
+func (s *Server) Manifest() data.ClusterManifest {
+	placement := s.Reg.Placement
+	if placement == "" {
+		placement = PlacementHashed
+	}
+	split := s.Reg.SplitStrategy
+	if split == "" {
+		split = DefaultSplit
+	}
+	return data.ClusterManifest{
+		Dimension:   s.Reg.Dimension,
+		Redundancy:  s.Reg.Redundancy,
+		Hasher:      s.Reg.Hasher.Name(),
+		Placement:   placement,
+		Split:       split,
+		Torus:       s.Reg.Torus,
+		Protocol:    ProtocolVersion,
+		MinProtocol: MinProtocolVersion,
+		Node:        s.ID,
+	}
+}
+
+// CheckManifest - Ensure a joiner's manifest matches the CAN's, listing every difference in the error
+func CheckManifest(can, joiner data.ClusterManifest) error {
+	diffs := []string{}
+	differ := func(name string, want, got interface{}) {
+		if want != got {
+			diffs = append(diffs, fmt.Sprintf("%s is %v, joiner has %v", name, want, got))
+		}
+	}
+
+	// Each side must speak a version the other still understands
+	if joiner.Protocol < can.MinProtocol || can.Protocol < joiner.MinProtocol {
+		diffs = append(diffs, fmt.Sprintf("protocol versions %d-%d, joiner speaks %d-%d",
+			can.MinProtocol, can.Protocol, joiner.MinProtocol, joiner.Protocol))
+	}
+	differ("dimension", can.Dimension, joiner.Dimension)
+	differ("redundancy", can.Redundancy, joiner.Redundancy)
+	differ("hasher", can.Hasher, joiner.Hasher)
+	differ("placement", can.Placement, joiner.Placement)
+	differ("split strategy", can.Split, joiner.Split)
+	differ("torus", can.Torus, joiner.Torus)
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("%w: %s", ErrIncompatible, strings.Join(diffs, "; "))
+	}
+	return nil
+}
+
+// checkJoiner - Ensure a joiner sent a manifest matching ours, joiners older than manifests send none
+func (s *Server) checkJoiner(manifest *data.ClusterManifest) error {
+	if manifest == nil {
+		return fmt.Errorf("%w: joiner sent no cluster manifest, protocol version %d or later is required",
+			ErrIncompatible, MinProtocolVersion)
+	}
+	return CheckManifest(s.Manifest(), *manifest)
+}
+
+// Cluster - Send the manifest of parameters a server must share to join this CAN
+func (s *Server) Cluster(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered Cluster method")
+	w.Header().Add("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(s.Manifest())
+
+	log.Info("Exiting Cluster method")
+}
+
+// AdoptHasher - Take the hasher of the CAN a seed belongs to, for a server started without one chosen
+//
+// The region's hasher is read without its lock, so this must be called before the server starts serving.
+func (s *Server) AdoptHasher(host string) error {
+	can, err := s.fetchManifest(ParseHost(host, s.Scheme))
+	if err != nil {
+		return err
+	}
+	hasher, err := GetHasher(can.Hasher)
+	if err != nil {
+		return err
+	}
+	s.Reg.Hasher = hasher
+	log.Print("Using the CAN's hasher " + hasher.Name())
+	return nil
+}
+
+// fetchManifest - Request the manifest of the CAN a seed belongs to
+func (s *Server) fetchManifest(hst Host) (*data.ClusterManifest, error) {
+	resp, err := s.C.Get(hst.URL("/cluster"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Cluster manifest request failed with status " + resp.Status)
+	}
+	manifest := &data.ClusterManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Names of the hashers a CAN can be created with
+const (
+	HasherFNV    = "fnv"
+	HasherSHA256 = "sha256"
+	HasherXXHash = "xxhash"
+)
+
+// DefaultHasher - Hasher new CANs are created with when none is specified
+//
+// FNV was the default before, but keys differing only in their last byte collide under it, so it must
+// now be chosen by name. Cluster manifests keep a server from joining a CAN with a different hasher.
+const DefaultHasher = HasherXXHash
+
+// Hasher - Maps a key to a point in d-dimensional space with every coordinate in [0,1)
+type Hasher interface {
+	Name() string
+	HashToPoint(key string, dim int) Point
+}
+
+// GetHasher - Look up a hasher by name, an empty name, as sent by servers older than named hashers,
+// selects FNV
+func GetHasher(name string) (Hasher, error) {
+	switch name {
+	case "", HasherFNV:
+		return FNVHasher{}, nil
+	case HasherSHA256:
+		return SHA256Hasher{}, nil
+	case HasherXXHash:
+		return XXHasher{}, nil
+	}
+	return nil, errors.New("Unknown hasher " + strconv.Quote(name))
+}
+
+// HasherNames - List the names of all available hashers
+func HasherNames() []string {
+	return []string{HasherFNV, HasherSHA256, HasherXXHash}
+}
+
+// FNVHasher - The original hasher, each coordinate is the FNV hash of the previous coordinate's string form
+type FNVHasher struct{}
+
+// Name - Return the name of the hasher
+func (FNVHasher) Name() string { return HasherFNV }
+
+// HashToPoint - Hash a key into a d-dimensional point
+func (FNVHasher) HashToPoint(key string, dim int) Point {
+	return HashStringToPoint(key, dim)
+}
+
+// SHA256Hasher - Hashes the key once per dimension with a per-dimension salt
+type SHA256Hasher struct{}
+
+// Name - Return the name of the hasher
+func (SHA256Hasher) Name() string { return HasherSHA256 }
+
+// HashToPoint - Hash a key into a d-dimensional point
+func (SHA256Hasher) HashToPoint(key string, dim int) Point {
+	coords := make([]float64, dim)
+	salt := make([]byte, 4)
+
+	for i := range coords {
+		binary.BigEndian.PutUint32(salt, uint32(i))
+		h := sha256.New()
+		h.Write(salt)
+		h.Write([]byte(key))
+		coords[i] = uint64ToUnit(binary.BigEndian.Uint64(h.Sum(nil)))
+	}
+
+	return Point{Coords: coords}
+}
+
+// XXHasher - Hashes the key with xxHash64, salting each dimension with its index
+type XXHasher struct{}
+
+// Name - Return the name of the hasher
+func (XXHasher) Name() string { return HasherXXHash }
+
+// HashToPoint - Hash a key into a d-dimensional point
+func (XXHasher) HashToPoint(key string, dim int) Point {
+	coords := make([]float64, dim)
+	buf := make([]byte, 8+len(key))
+	copy(buf[8:], key)
+
+	for i := range coords {
+		binary.BigEndian.PutUint64(buf, uint64(i))
+		coords[i] = uint64ToUnit(xxhash.Sum64(buf))
+	}
+
+	return Point{Coords: coords}
+}
+
+// uint64ToUnit - Map a 64 bit hash onto [0,1) using its top 53 bits, so the result is never 1.0
+func uint64ToUnit(h uint64) float64 {
+	return float64(h>>11) / (1 << 53)
+}
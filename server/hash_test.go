@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestGetHasher(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{name: "", want: HasherFNV, ok: true}, // Sent by servers older than named hashers
+		{name: HasherFNV, want: HasherFNV, ok: true},
+		{name: HasherSHA256, want: HasherSHA256, ok: true},
+		{name: HasherXXHash, want: HasherXXHash, ok: true},
+		{name: "md5"},
+	}
+
+	for _, tt := range tests {
+		hasher, err := GetHasher(tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("GetHasher(%q) returned %v", tt.name, err)
+			continue
+		}
+		if tt.ok && hasher.Name() != tt.want {
+			t.Errorf("GetHasher(%q) is %s, want %s", tt.name, hasher.Name(), tt.want)
+		}
+	}
+	if _, err := GetHasher(DefaultHasher); err != nil {
+		t.Errorf("Default hasher %s is unknown", DefaultHasher)
+	}
+}
+
+func TestHashersStayInUnitSpace(t *testing.T) {
+	for _, name := range HasherNames() {
+		t.Run(name, func(t *testing.T) {
+			hasher, _ := GetHasher(name)
+			for _, dim := range []int{1, 2, 5, 16} {
+				for k := 0; k < 200; k++ {
+					key := fmt.Sprint("key-", k)
+					pt := hasher.HashToPoint(key, dim)
+					if len(pt.Coords) != dim {
+						t.Fatalf("%s hashed into %d coordinates, want %d", key, len(pt.Coords), dim)
+					}
+					for _, c := range pt.Coords {
+						if c < 0 || c >= 1 || math.IsNaN(c) {
+							t.Fatalf("%s hashed to %v, outside [0,1)", key, pt.Coords)
+						}
+					}
+					if again := hasher.HashToPoint(key, dim); !reflect.DeepEqual(again.Coords, pt.Coords) {
+						t.Fatalf("%s hashed to %v and then %v", key, pt.Coords, again.Coords)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSaltedHashersSpreadDimensions(t *testing.T) {
+	// Salted hashers give each dimension its own hash, so no two coordinates of a key repeat
+	for _, name := range []string{HasherSHA256, HasherXXHash} {
+		hasher, _ := GetHasher(name)
+		for k := 0; k < 100; k++ {
+			pt := hasher.HashToPoint(fmt.Sprint("key-", k), 4)
+			for i := 1; i < len(pt.Coords); i++ {
+				if pt.Coords[i] == pt.Coords[0] {
+					t.Fatalf("%s repeated a coordinate in %v", name, pt.Coords)
+				}
+			}
+		}
+	}
+}
+
+func TestUint64ToUnit(t *testing.T) {
+	tests := []struct {
+		h    uint64
+		want float64
+	}{
+		{h: 0, want: 0},
+		{h: 1 << 63, want: 0.5},
+		{h: math.MaxUint64, want: 1 - math.Ldexp(1, -53)},
+		{h: 1<<11 - 1, want: 0}, // Only the top 53 bits count
+	}
+
+	for _, tt := range tests {
+		if got := uint64ToUnit(tt.h); got != tt.want {
+			t.Errorf("uint64ToUnit(%#x) is %v, want %v", tt.h, got, tt.want)
+		}
+	}
+}
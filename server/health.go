@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"main/data"
+)
+
+// Membership states of a server, reported by /healthz and /readyz
+const (
+	StateStarting = "starting" // Not yet a member of a CAN
+	StateJoining  = "joining"  // Waiting for a seed to hand over a zone
+	StateReady    = "ready"    // Owns a zone and serves requests
+	StateHandoff  = "handoff"  // Handing half of its zone to a joiner
+	StateLeaving  = "leaving"  // Shutting down, no longer taking requests
+)
+
+// stateMessages - Explanations of each membership state for probes
+var stateMessages = map[string]string{
+	StateStarting: "Server has not joined a CAN yet",
+	StateJoining:  "Server is waiting for a seed to hand over its zone",
+	StateReady:    "Server owns a zone and serves requests",
+	StateHandoff:  "Server is handing half of its zone to a joiner, writes to that half are refused until it commits",
+	StateLeaving:  "Server is shutting down",
+}
+
+// SetState - Record the server's progress through membership, handoffs are tracked on their own
+func (s *Server) SetState(state string) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.state = state
+}
+
+// State - The server's current membership state
+func (s *Server) State() string {
+	s.stateMu.Lock()
+	state := s.state
+	s.stateMu.Unlock()
+
+	if state != StateReady {
+		return state
+	}
+
+	// A reserved split lasts from the join request until the joiner commits or the reservation is rolled back
+	s.joinMu.Lock()
+	defer s.joinMu.Unlock()
+	if s.pending != nil {
+		return StateHandoff
+	}
+	return state
+}
+
+// health - Describe the server's membership state for probes
+func (s *Server) health() *data.HealthResponse {
+	state := s.State()
+	return &data.HealthResponse{
+		ID:        s.ID,
+		State:     state,
+		Ready:     state == StateReady,
+		Message:   stateMessages[state],
+		Zone:      string(s.Reg.Geometry().Zone),
+		Neighbors: len(s.Reg.NeighborList()),
+	}
+}
+
+// Healthz - Report that the server is alive, whatever its membership state
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.health())
+}
+
+// Readyz - Report whether the server should receive requests, failing with 503 unless it is ready
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	hRes := s.health()
+	if !hRes.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(hRes)
+}
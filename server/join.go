@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"main/data"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultJoinTimeout - Time a joiner has to commit before the split reserved for it is rolled back
+const DefaultJoinTimeout = 10 * time.Second
+
+// commitRetryInterval - Wait before a joiner first retries a commit the owner did not answer, doubling each time
+const commitRetryInterval = 100 * time.Millisecond
+
+// commitRounds - Join timeouts a joiner retries an unanswered commit for, before giving up on an owner
+// which has likely died
+const commitRounds = 3
+
+// rememberedCommits - Number of committed join tokens an owner keeps to acknowledge retried commits
+const rememberedCommits = 16
+
+// pendingJoin - A split reserved for a joiner which has not yet confirmed it holds its half
+type pendingJoin struct {
+	token  string
+	joiner Host
+	plan   *SplitPlan
+	timer  *time.Timer
+}
+
+// acquireJoinSlot - Wait until no other join is being handed out by this server, for at most the join
+// timeout, returning false if the wait timed out
+func (s *Server) acquireJoinSlot() bool {
+	timer := time.NewTimer(s.JoinTimeout)
+	defer timer.Stop()
+
+	select {
+	case s.joinSlot <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// releaseJoinSlot - Let the next join waiting at this server go ahead
+func (s *Server) releaseJoinSlot() {
+	<-s.joinSlot
+}
+
+// reserveJoin - Hold a planned split for a joiner until it commits or the join timeout passes,
+// returning the token the joiner commits with
+func (s *Server) reserveJoin(plan *SplitPlan, joiner Host) string {
+	s.joinMu.Lock()
+	defer s.joinMu.Unlock()
+
+	token := newNodeID()
+	s.pending = &pendingJoin{
+		token:  token,
+		joiner: joiner,
+		plan:   plan,
+	}
+	s.pending.timer = time.AfterFunc(s.JoinTimeout, func() { s.rollbackJoin(token) })
+	return token
+}
+
+// rollbackJoin - Give up on a join which was not committed in time, keeping our whole zone
+func (s *Server) rollbackJoin(token string) {
+	s.joinMu.Lock()
+	defer s.joinMu.Unlock()
+
+	if s.pending == nil || s.pending.token != token {
+		return
+	}
+	s.Reg.CancelSplit(s.pending.plan)
+	log.WithFields(logrus.Fields{
+		"joiner": s.pending.joiner.String(),
+	}).Warn("Join was not committed in time, rolled back split")
+	s.pending = nil
+	s.releaseJoinSlot()
+}
+
+// CommitJoin - Apply the split reserved for a joiner once it confirms it holds its half, then tell
+// our neighbors about our smaller zone
+func (s *Server) CommitJoin(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered CommitJoin method")
+	w.Header().Add("Content-Type", "application/json")
+
+	jc := data.JoinCommitRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&jc); err != nil {
+		log.Warn(err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	s.joinMu.Lock()
+	pending := s.pending
+	if (pending == nil || pending.token != jc.Token) && jc.Token != "" && s.committed(jc.Token) {
+		// The joiner did not hear our answer to a commit we already applied
+		s.joinMu.Unlock()
+		log.Info("Acknowledged repeated commit from " + jc.ID)
+		return
+	}
+	if pending == nil || pending.token != jc.Token {
+		s.joinMu.Unlock()
+		log.Warn("Rejected commit from " + jc.ID + " without a pending join")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: "No join is pending with this token, it may have timed out"})
+		return
+	}
+	pending.timer.Stop()
+	delNeighbors := s.Reg.ApplySplit(pending.plan)
+	s.pending = nil
+	s.commits = append(s.commits, pending.token)
+	if len(s.commits) > rememberedCommits {
+		s.commits = s.commits[1:]
+	}
+	s.joinMu.Unlock()
+
+	log.WithFields(logrus.Fields{
+		"joiner": jc.ID,
+		"range":  s.Reg.Geometry().Space,
+	}).Info("Committed split for joiner")
+
+	// Point watchers of keys which moved at the joiner
+	s.Watchers.Handoff(s.Reg, pending.joiner.URL(""))
+
+	// The next join waits until our neighbors have heard of this one, so updates arrive in join order
+	s.announceSplit(delNeighbors)
+	s.introduceLate(pending.plan, delNeighbors)
+	s.releaseJoinSlot()
+
+	log.Info("Exiting CommitJoin method")
+}
+
+// committed - Determine if a join with this token was committed here recently, the caller must hold joinMu
+func (s *Server) committed(token string) bool {
+	for _, t := range s.commits {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+// sendCommit - Ask the owner to apply the split it reserved for us, retrying until it answers, and
+// returning an error if it refuses
+//
+// An unanswered commit may still have been applied, so neither keeping nor giving up the zone is safe
+// until the owner answers. Once its join timeout has passed it either committed or rolled back, and says
+// which when asked again. An owner silent for commitRounds join timeouts is taken to have died, and the
+// join fails so another seed can be tried.
+func (s *Server) sendCommit(owner Neighbor, token string) error {
+	body, _ := json.Marshal(&data.JoinCommitRequest{ID: s.ID, Token: token})
+	deadline := time.Now().Add(commitRounds * s.JoinTimeout)
+	wait := commitRetryInterval
+	for {
+		req, err := s.newSignedRequest(owner.ID, http.MethodPost, owner.URL("/join/commit"), body)
+		if err != nil {
+			return err
+		}
+		resp, err := s.C.Do(req)
+		if err == nil {
+			resBody, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			if resp.StatusCode < http.StatusInternalServerError {
+				eRes := data.ErrorResponse{}
+				json.Unmarshal(resBody, &eRes)
+				return errors.New("Owner refused the commit with status " + resp.Status + ": " + eRes.Message)
+			}
+			err = errors.New("status " + resp.Status)
+		}
+
+		if time.Now().Add(wait).After(deadline) {
+			return errors.New("Owner did not answer the commit within " + (commitRounds * s.JoinTimeout).String() + ": " + err.Error())
+		}
+		log.Warn("Could not confirm join commit with owner, retrying: " + err.Error())
+		time.Sleep(wait)
+		if wait *= 2; wait > s.JoinTimeout {
+			wait = s.JoinTimeout
+		}
+	}
+}
+
+// announceSplit - Send our smaller zone to our neighbors, and ask those no longer bordering it to drop us,
+// both in order of node ID
+func (s *Server) announceSplit(delNeighbors []Neighbor) {
+	geo := s.Reg.Geometry()
+	neighborReq := &data.NeighborRequest{
+		ID:      s.ID,
+		Address: s.Advertise.String(),
+		Range:   *(geo.Space.GetRangeResponse()),
+		Zone:    string(geo.Zone),
+		Epoch:   geo.Epoch,
+	}
+
+	body, _ := json.Marshal(neighborReq)
+
+	// Request existing neighbors to update my range in their map
+	for _, neighbor := range s.Reg.NeighborList() {
+		req, _ := s.newSignedRequest(neighbor.ID, http.MethodPatch, neighbor.URL("/neighbors"), body)
+		resp, err := s.C.Do(req)
+		if err != nil {
+			log.Warn("Could not update neighbor " + neighbor.ID + ": " + err.Error())
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	// Request neighbors that are no longer adjacent to delete me
+	for _, neighbor := range delNeighbors {
+		req, _ := s.newSignedRequest(neighbor.ID, http.MethodDelete, neighbor.URL("/neighbors?id="+url.QueryEscape(s.ID)), body)
+		resp, err := s.C.Do(req)
+		if err != nil {
+			log.Warn("Could not update neighbor " + neighbor.ID + ": " + err.Error())
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// introducedParam - Query parameter naming the server which passed on an introduction, so it is not passed on again
+const introducedParam = "via"
+
+// introduce - Tell a server about another on behalf of both, as a server which knows each of them
+func (s *Server) introduce(to, about Neighbor) {
+	body, _ := json.Marshal(&data.NeighborRequest{
+		ID:      about.ID,
+		Address: about.Host.String(),
+		Range:   *(about.Space.GetRangeResponse()),
+		Zone:    string(about.Zone),
+		Epoch:   about.Epoch,
+	})
+	req, _ := s.newSignedRequest(to.ID, http.MethodPut, to.URL("/neighbors?"+introducedParam+"="+url.QueryEscape(s.ID)), body)
+	resp, err := s.C.Do(req)
+	if err != nil {
+		log.Warn("Could not introduce " + about.ID + " to " + to.ID + ": " + err.Error())
+		return
+	}
+	resp.Body.Close()
+}
+
+// passOn - Introduce a server whose zone does not border ours to those of our neighbors whose zones it
+// borders, and them to it
+//
+// Zones cut at the same time introduce themselves to the servers they were cut from, which may no longer
+// border them, and no other server is left to tell them they touch.
+func (s *Server) passOn(stray Neighbor) {
+	for _, neighbor := range s.Reg.NeighborList() {
+		if neighbor.ID != stray.ID && s.Reg.zonesBorder(&neighbor, &stray) {
+			s.introduce(neighbor, stray)
+			s.introduce(stray, neighbor)
+		}
+	}
+}
+
+// introduceLate - Introduce a joiner to the neighbors we heard of after its half was planned, which its
+// copy of our neighbor table lacks, and them to it
+func (s *Server) introduceLate(plan *SplitPlan, delNeighbors []Neighbor) {
+	if plan.Joiner == nil {
+		return
+	}
+	known := make(map[string]bool)
+	for _, neighbor := range plan.NewReg.NeighborList() {
+		known[neighbor.ID] = true
+	}
+
+	for _, neighbor := range append(s.Reg.NeighborList(), delNeighbors...) {
+		if known[neighbor.ID] || neighbor.ID == plan.Joiner.ID || !s.Reg.zonesBorder(&neighbor, plan.Joiner) {
+			continue
+		}
+		known[neighbor.ID] = true
+		s.introduce(*plan.Joiner, neighbor)
+		s.introduce(neighbor, *plan.Joiner)
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// MerkleDepth - Levels below the root of the Merkle trees replicas compare, giving 2^MerkleDepth buckets
+const MerkleDepth = 8
+
+// MerkleTree - Hashes over the entries of a zone, bucketed by where their keys hash to within it
+type MerkleTree struct {
+	Depth  int
+	Levels [][][]byte // Levels[0] holds the root, Levels[Depth] the buckets
+}
+
+// Bucket - Find the bucket of a point within a zone, halving the zone along each axis in turn
+//
+// Buckets follow the zone rather than the whole space, so a small zone still spreads its keys
+// over every bucket.
+func Bucket(pt Point, space *Range, depth int) int {
+	lo := append([]float64(nil), space.P1.Coords...)
+	hi := append([]float64(nil), space.P2.Coords...)
+
+	bucket := 0
+	for i := 0; i < depth; i++ {
+		axis := i % len(lo)
+		mid := (lo[axis] + hi[axis]) / 2
+		bucket <<= 1
+		if pt.Coords[axis] >= mid {
+			bucket |= 1
+			lo[axis] = mid
+		} else {
+			hi[axis] = mid
+		}
+	}
+	return bucket
+}
+
+// BuildMerkleTree - Hash a zone's entries into a tree of the given depth, using hash to place keys
+func BuildMerkleTree(entries []Entry, space *Range, hash func(string) Point, depth int) *MerkleTree {
+	buckets := make([][]Entry, 1<<depth)
+	for _, e := range entries {
+		b := Bucket(hash(e.Key), space, depth)
+		buckets[b] = append(buckets[b], e)
+	}
+
+	t := &MerkleTree{Depth: depth, Levels: make([][][]byte, depth+1)}
+	leaves := make([][]byte, len(buckets))
+	for i, bucket := range buckets {
+		leaves[i] = hashBucket(bucket)
+	}
+	t.Levels[depth] = leaves
+
+	for level := depth - 1; level >= 0; level-- {
+		below := t.Levels[level+1]
+		nodes := make([][]byte, len(below)/2)
+		for i := range nodes {
+			h := sha256.New()
+			h.Write(below[2*i])
+			h.Write(below[2*i+1])
+			nodes[i] = h.Sum(nil)
+		}
+		t.Levels[level] = nodes
+	}
+	return t
+}
+
+// hashBucket - Hash the entries of one bucket in key order
+func hashBucket(entries []Entry) []byte {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	h := sha256.New()
+	for _, e := range entries {
+		h.Write([]byte(e.Key))
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.FormatUint(e.Record.Version, 10)))
+		h.Write([]byte{0})
+		h.Write([]byte(e.Record.Checksum))
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.FormatBool(e.Deleted)))
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum(nil)
+}
+
+// Root - The hash of the whole tree
+func (t *MerkleTree) Root() []byte {
+	return t.Levels[0][0]
+}
+
+// Diff - Return the buckets whose hashes differ between two trees, only descending into subtrees
+// whose hashes differ
+func (t *MerkleTree) Diff(other *MerkleTree) []int {
+	if string(t.Root()) == string(other.Root()) {
+		return []int{}
+	}
+
+	differ := []int{0}
+	for level := 1; level <= t.Depth && len(differ) > 0; level++ {
+		next := []int{}
+		for _, parent := range differ {
+			for _, child := range []int{2 * parent, 2*parent + 1} {
+				if string(t.Levels[level][child]) != string(other.Levels[level][child]) {
+					next = append(next, child)
+				}
+			}
+		}
+		differ = next
+	}
+	return differ
+}
+
+// Encode - Format the tree's hashes as hex strings for transmission
+func (t *MerkleTree) Encode() [][]string {
+	levels := make([][]string, len(t.Levels))
+	for i, level := range t.Levels {
+		levels[i] = make([]string, len(level))
+		for j, node := range level {
+			levels[i][j] = hex.EncodeToString(node)
+		}
+	}
+	return levels
+}
+
+// DecodeMerkleTree - Parse a transmitted tree, checking it has the expected depth
+func DecodeMerkleTree(levels [][]string, depth int) (*MerkleTree, error) {
+	if len(levels) != depth+1 {
+		return nil, errors.New("Merkle tree has " + strconv.Itoa(len(levels)-1) + " levels, expected " + strconv.Itoa(depth))
+	}
+
+	t := &MerkleTree{Depth: depth, Levels: make([][][]byte, len(levels))}
+	for i, level := range levels {
+		if len(level) != 1<<i {
+			return nil, errors.New("Merkle tree level " + strconv.Itoa(i) + " has the wrong number of hashes")
+		}
+		t.Levels[i] = make([][]byte, len(level))
+		for j, node := range level {
+			decoded, err := hex.DecodeString(node)
+			if err != nil {
+				return nil, err
+			}
+			t.Levels[i][j] = decoded
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	// Buckets halve the upper half of the space along the first axis, then the second
+	zone := &Range{P1: Point{[]float64{0.5, 0}}, P2: Point{[]float64{1, 1}}}
+	tests := []struct {
+		pt     []float64
+		bucket int
+	}{
+		{pt: []float64{0.5, 0}, bucket: 0},
+		{pt: []float64{0.6, 0.75}, bucket: 1},
+		{pt: []float64{0.75, 0}, bucket: 2},
+		{pt: []float64{0.99, 0.5}, bucket: 3},
+	}
+
+	for _, tt := range tests {
+		if b := Bucket(Point{tt.pt}, zone, 2); b != tt.bucket {
+			t.Errorf("Point %v is in bucket %d, want %d", tt.pt, b, tt.bucket)
+		}
+	}
+	if b := Bucket(Point{[]float64{0.99, 0.99}}, zone, 0); b != 0 {
+		t.Errorf("A tree without levels put a point in bucket %d", b)
+	}
+}
+
+func TestMerkleDiff(t *testing.T) {
+	// Keys name the point they hash to, so each lands in a known bucket of a depth 2 tree
+	hash := func(key string) Point {
+		var x, y float64
+		fmt.Sscanf(key[strings.Index(key, "@")+1:], "%g,%g", &x, &y)
+		return Point{[]float64{x, y}}
+	}
+	entry := func(key string, version uint64, checksum string, deleted bool) Entry {
+		return Entry{Key: key, Record: Record{Version: version, Checksum: checksum}, Deleted: deleted}
+	}
+	base := []Entry{
+		entry("a@0.1,0.1", 1, "x", false),
+		entry("b@0.1,0.9", 1, "x", false),
+		entry("c@0.9,0.1", 1, "x", false),
+		entry("d@0.9,0.9", 1, "x", false),
+	}
+	with := func(changes ...Entry) []Entry {
+		entries := append([]Entry(nil), base...)
+		for _, c := range changes {
+			replaced := false
+			for i := range entries {
+				if entries[i].Key == c.Key {
+					entries[i], replaced = c, true
+				}
+			}
+			if !replaced {
+				entries = append(entries, c)
+			}
+		}
+		return entries
+	}
+
+	tests := []struct {
+		name   string
+		theirs []Entry
+		differ []int
+	}{
+		{name: "same entries", theirs: with(), differ: []int{}},
+		{name: "newer version", theirs: with(entry("c@0.9,0.1", 2, "x", false)), differ: []int{2}},
+		{name: "other checksum", theirs: with(entry("b@0.1,0.9", 1, "y", false)), differ: []int{1}},
+		{name: "deleted", theirs: with(entry("d@0.9,0.9", 1, "x", true)), differ: []int{3}},
+		{name: "extra keys", theirs: with(entry("e@0.2,0.2", 1, "x", false), entry("f@0.8,0.8", 1, "x", false)), differ: []int{0, 3}},
+		{name: "missing key", theirs: base[1:], differ: []int{0}},
+	}
+
+	zone := &Range{P1: Point{[]float64{0, 0}}, P2: Point{[]float64{1, 1}}}
+	ours := BuildMerkleTree(append([]Entry(nil), base...), zone, hash, 2)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			theirs := BuildMerkleTree(tt.theirs, zone, hash, 2)
+			if differ := ours.Diff(theirs); !reflect.DeepEqual(differ, tt.differ) {
+				t.Fatalf("Buckets %v differ, want %v", differ, tt.differ)
+			}
+			if same := len(tt.differ) == 0; same != (string(ours.Root()) == string(theirs.Root())) {
+				t.Fatalf("Roots match is %v, want %v", !same, same)
+			}
+		})
+	}
+}
+
+func TestDecodeMerkleTree(t *testing.T) {
+	zone := &Range{P1: Point{[]float64{0, 0}}, P2: Point{[]float64{1, 1}}}
+	hasher, _ := GetHasher(DefaultHasher)
+	hash := func(key string) Point { return hasher.HashToPoint(key, 2) }
+	tree := BuildMerkleTree([]Entry{{Key: "a"}, {Key: "b"}}, zone, hash, 3)
+
+	tests := []struct {
+		name   string
+		levels [][]string
+		depth  int
+		valid  bool
+	}{
+		{name: "round trip", levels: tree.Encode(), depth: 3, valid: true},
+		{name: "other depth", levels: tree.Encode(), depth: 4},
+		{name: "no levels", levels: nil, depth: 0},
+		{name: "short level", levels: append(tree.Encode()[:2], []string{"00"}, tree.Encode()[3]), depth: 3},
+		{name: "not hex", levels: [][]string{{"zz"}}, depth: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeMerkleTree(tt.levels, tt.depth)
+			if (err == nil) != tt.valid {
+				t.Fatalf("DecodeMerkleTree returned %v, want valid %v", err, tt.valid)
+			}
+			if tt.valid && len(decoded.Diff(tree)) != 0 {
+				t.Fatal("Decoded tree differs from the one encoded")
+			}
+		})
+	}
+}
+
+func TestEntrySupersedes(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Entry
+		want bool
+	}{
+		{name: "newer version", a: Entry{Record: Record{Version: 2}}, b: Entry{Record: Record{Version: 1}}, want: true},
+		{name: "older version", a: Entry{Record: Record{Version: 1}, Deleted: true}, b: Entry{Record: Record{Version: 2}}},
+		{name: "deletion beats a write", a: Entry{Record: Record{Version: 1}, Deleted: true}, b: Entry{Record: Record{Version: 1, Checksum: "z"}}, want: true},
+		{name: "larger checksum", a: Entry{Record: Record{Version: 1, Checksum: "b"}}, b: Entry{Record: Record{Version: 1, Checksum: "a"}}, want: true},
+		{name: "same entry", a: Entry{Record: Record{Version: 1, Checksum: "a"}}, b: Entry{Record: Record{Version: 1, Checksum: "a"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Supersedes(&tt.b); got != tt.want {
+				t.Fatalf("Supersedes is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
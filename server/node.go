@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// nodeIDBytes - Length of a generated node ID before hex encoding
+const nodeIDBytes = 16
+
+// newNodeID - Generate a random node ID
+func newNodeID() string {
+	buf := make([]byte, nodeIDBytes)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// LoadNodeID - Read this server's node ID from file, generating and saving one if the file does not exist
+//
+// Without a file the ID only lasts as long as the process.
+func LoadNodeID(file string) (string, error) {
+	if file == "" {
+		return newNodeID(), nil
+	}
+
+	contents, err := ioutil.ReadFile(file)
+	if err == nil {
+		id := strings.TrimSpace(string(contents))
+		if id == "" {
+			return "", errors.New("Node ID file " + file + " is empty")
+		}
+		return id, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id := newNodeID()
+	if err := ioutil.WriteFile(file, []byte(id+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// fillAddress - Complete an advertised address which left out its IP with the IP a request came from
+func fillAddress(addr string, r *http.Request) Host {
+	host := ParseHost(addr, "")
+	if host.IP == "" {
+		host.IP, _ = getHostFromRemoteAddr(r.RemoteAddr)
+	}
+	return host
+}
+
+// Self - This server as a neighbor, with the address other servers should use to reach it
+//
+// If no IP was advertised, the address the request r was sent to is used.
+func (s *Server) Self(r *http.Request) Neighbor {
+	host := s.Advertise
+	if host.IP == "" && r != nil {
+		host.IP = ParseHost(r.Host, "").IP
+	}
+	geo := s.Reg.Geometry()
+	return Neighbor{
+		ID:    s.ID,
+		Host:  host,
+		Space: geo.Space,
+		Zone:  geo.Zone,
+		Epoch: geo.Epoch,
+	}
+}
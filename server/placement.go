@@ -0,0 +1,54 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Names of the key placement modes a CAN can be created with
+const (
+	PlacementHashed  = "hashed"
+	PlacementOrdered = "ordered"
+)
+
+// OrderedAxis - Dimension holding the order-preserving coordinate under ordered placement
+const OrderedAxis = 0
+
+// orderBytes - Number of leading key bytes encoded into the ordered coordinate, 48 bits fit a float64 exactly
+const orderBytes = 6
+
+// CheckPlacement - Ensure a placement mode is known, an empty name means hashed placement
+func CheckPlacement(name string) error {
+	switch name {
+	case "", PlacementHashed, PlacementOrdered:
+		return nil
+	}
+	return errors.New("Unknown placement " + strconv.Quote(name))
+}
+
+// PlacementNames - List the names of all placement modes
+func PlacementNames() []string {
+	return []string{PlacementHashed, PlacementOrdered}
+}
+
+// orderKey - Map a key onto [0,1) so that lexicographically smaller keys never map to a larger value
+func orderKey(key string) float64 {
+	val, scale := 0.0, 1.0
+	for i := 0; i < orderBytes && i < len(key); i++ {
+		scale /= 256
+		val += float64(key[i]) * scale
+	}
+	return val
+}
+
+// prefixEnd - Return the smallest key greater than every key starting with prefix, empty if there is none
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
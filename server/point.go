@@ -20,14 +20,22 @@ func (pt *Point) Copy() *Point {
 	return newP
 }
 
-// hash - Take a string and hash it to a float64 value
+// hash - Take a string and hash it to a float64 value in [0,1)
 func hash(s string) float64 {
 	h := fnv.New64()
 	h.Write([]byte(s))
-	return float64(float64(h.Sum64()) / math.MaxUint64)
+	return unitInterval(float64(float64(h.Sum64()) / math.MaxUint64))
 }
 
-// HashStringToPoint - Hash a string into a d-dimensional point
+// unitInterval - Clamp a value into [0,1), since large hashes may round up to exactly 1.0
+func unitInterval(val float64) float64 {
+	if val >= 1 {
+		return math.Nextafter(1, 0)
+	}
+	return val
+}
+
+// HashStringToPoint - Hash a string into a d-dimensional point using the legacy FNV chain
 func HashStringToPoint(key string, dim int) Point {
 	array := make([]float64, dim)
 	point := new(Point)
@@ -96,6 +104,17 @@ func (pt *Point) Dist(b Point) float64 {
 	return pt.Sub(b).Magnitude()
 }
 
+// TorusDist - Return the distance between two points in a unit space whose sides wrap around
+func (pt *Point) TorusDist(b Point) float64 {
+	sum := 0.0
+	for i, val := range pt.Coords {
+		diff := math.Abs(val - b.Coords[i])
+		diff = math.Min(diff, 1-diff)
+		sum += diff * diff
+	}
+	return math.Sqrt(sum)
+}
+
 // Midpoint - Find the midpoint between two points
 func (pt *Point) Midpoint(b Point) *Point {
 	return pt.Add(b).Scale(0.5)
@@ -1,7 +1,7 @@
 package server
 
 import (
-	"fmt"
+	"errors"
 	"main/data"
 	"math"
 	"strconv"
@@ -41,86 +41,124 @@ func (r *Range) Dimensions() *Point {
 	return r.P2.Sub(r.P1)
 }
 
-// Split - Split a range into two halves, returning the new range
-func (r *Range) Split() *Range {
-	splitInd := 0
-	for i, val := range r.Dimensions().Coords {
-		if val > r.Dimensions().Coords[splitInd] {
-			splitInd = i
+// Copy - Returns a copy of a range which shares no coordinates with the original
+func (r *Range) Copy() *Range {
+	return &Range{
+		P1: *(r.P1.Copy()),
+		P2: *(r.P2.Copy()),
+	}
+}
+
+// Equal - Determine if two ranges have exactly the same bounds
+func (r *Range) Equal(other *Range) bool {
+	if len(r.P1.Coords) != len(other.P1.Coords) || len(r.P2.Coords) != len(other.P2.Coords) {
+		return false
+	}
+	for i := range r.P1.Coords {
+		if r.P1.Coords[i] != other.P1.Coords[i] || r.P2.Coords[i] != other.P2.Coords[i] {
+			return false
 		}
 	}
-	newDimVal := (r.P1.Coords[splitInd] + r.P2.Coords[splitInd]) / 2
+	return true
+}
+
+// Within - Determine if a range lies entirely inside another of the same dimension
+func (r *Range) Within(other *Range) bool {
+	if len(r.P1.Coords) != len(other.P1.Coords) {
+		return false
+	}
+	for i := range r.P1.Coords {
+		if r.P1.Coords[i] < other.P1.Coords[i] || r.P2.Coords[i] > other.P2.Coords[i] {
+			return false
+		}
+	}
+	return true
+}
 
+// Volume - Returns the product of a range's side lengths
+func (r *Range) Volume() float64 {
+	vol := 1.0
+	for _, val := range r.Dimensions().Coords {
+		vol *= val
+	}
+	return vol
+}
+
+// Split - Split a range into two halves along its longest edge, returning the new range
+func (r *Range) Split() *Range {
+	splitInd := r.longestAxis()
+	return r.SplitAt(splitInd, (r.P1.Coords[splitInd]+r.P2.Coords[splitInd])/2)
+}
+
+// SplitAt - Cut a range along an axis at a coordinate, keeping the lower part and returning the upper
+func (r *Range) SplitAt(axis int, at float64) *Range {
 	newRange := &Range{
 		P1: *(r.P1.Copy()),
 		P2: *(r.P2.Copy()),
 	}
 
-	newRange.P1.Coords[splitInd] = newDimVal
-	r.P2.Coords[splitInd] = newDimVal
+	newRange.P1.Coords[axis] = at
+	r.P2.Coords[axis] = at
 
 	return newRange
 }
 
-// stringPadZero - Pad a string s with enough zeroes to make it length len
-func stringPadZero(s string, len int) string {
-	return fmt.Sprintf("%0"+strconv.Itoa(len)+"s", s)
+// Neighbors - Determine if two ranges share a face, touching along exactly one dimension and
+// overlapping with positive length along every other
+func (r *Range) Neighbors(other *Range) bool {
+	return r.border(other, false)
 }
 
-// GetCorners - Returns a list containing all corners defined by a range
-func (r *Range) GetCorners() []Point {
-	dim := r.Dimensions()
-	numDim := len(dim.Coords)
-	perms := []string{}
-	for i := 0; i < int(math.Pow(2, float64(numDim))); i++ {
-		perms = append(perms, stringPadZero(strconv.FormatInt(int64(i), 2), numDim))
-	}
-
-	corners := []Point{}
-	for _, perm := range perms {
-		pt := *new(Point)
-		for i, char := range perm {
-			if char == '0' {
-				pt.Coords = append(pt.Coords, r.P1.Coords[i])
-			} else {
-				pt.Coords = append(pt.Coords, r.P2.Coords[i])
-			}
-		}
-		corners = append(corners, pt)
-	}
-	return corners
+// NeighborsOnTorus - Determine if two ranges share a face when each side of the unit space wraps
+// around to the opposite side
+func (r *Range) NeighborsOnTorus(other *Range) bool {
+	return r.border(other, true)
 }
 
-// PointInside - Determines if a point is contained in a given range (for neighbor updates)
-func (r *Range) PointInside(pt *Point) bool {
+// border - Compare two ranges one dimension at a time, so the test takes time linear in the dimension
+// and finds faces which only partly overlap
+func (r *Range) border(other *Range, torus bool) bool {
+	touching := 0
 	for i := range r.P1.Coords {
-		if !(r.P1.Coords[i] <= pt.Coords[i] && pt.Coords[i] <= r.P2.Coords[i]) {
+		lo, hi := r.P1.Coords[i], r.P2.Coords[i]
+		otherLo, otherHi := other.P1.Coords[i], other.P2.Coords[i]
+
+		switch {
+		case math.Min(hi, otherHi) > math.Max(lo, otherLo):
+			// The intervals overlap with positive length
+		case hi == otherLo || otherHi == lo:
+			touching++
+		case torus && (hi == 1 && otherLo == 0 || otherHi == 1 && lo == 0):
+			touching++
+		default:
 			return false
 		}
 	}
-	return true
+	return touching == 1
 }
 
-// DirectionalBorder - r is the "smaller" volume, other is the "larger" volume
-func (r *Range) DirectionalBorder(other *Range) bool {
-	contacts := 0
-	dim := len(r.Dimensions().Coords)
-	minContactPoints := int(math.Pow(2, float64(dim-1)))
-
-	for _, point := range r.GetCorners() {
-		if other.PointInside(&point) {
-			contacts++
-		}
-		if contacts >= minContactPoints {
-			return true
+// CheckRange - Ensure a range sent by another server has dim coordinates in each point and covers part of
+// the space, so no zone it is compared with can be indexed past its coordinates
+func CheckRange(rr data.RangeResponse, dim int) error {
+	if len(rr.P1.Coords) != dim || len(rr.P2.Coords) != dim {
+		return errors.New("Range must have " + strconv.Itoa(dim) + " coordinates in each point")
+	}
+	for i := range rr.P1.Coords {
+		if !(0 <= rr.P1.Coords[i] && rr.P1.Coords[i] < rr.P2.Coords[i] && rr.P2.Coords[i] <= 1) {
+			return errors.New("Range is empty or reaches outside the space")
 		}
 	}
-	return false
+	return nil
 }
 
-// Neighbors - Determine if two ranges share a face
-func (r *Range) Neighbors(other *Range) bool {
-	return r.DirectionalBorder(other) || other.DirectionalBorder(r)
+// checkNeighbors - Ensure every neighbor another server listed has a range fitting the space
+func checkNeighbors(neighbors map[string]data.NeighborResponse, dim int) error {
+	for id, nr := range neighbors {
+		if err := CheckRange(nr.Range, dim); err != nil {
+			return errors.New("Neighbor " + id + ": " + err.Error())
+		}
+	}
+	return nil
 }
 
 // UnpackRange - Unmarshal a RangeResponse into a range
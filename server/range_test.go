@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+
+	"main/data"
+)
+
+func TestCheckRange(t *testing.T) {
+	rng := func(p1, p2 []float64) data.RangeResponse {
+		return data.RangeResponse{P1: data.PointResponse{Coords: p1}, P2: data.PointResponse{Coords: p2}}
+	}
+
+	tests := []struct {
+		name  string
+		rr    data.RangeResponse
+		valid bool
+	}{
+		{name: "whole space", rr: rng([]float64{0, 0}, []float64{1, 1}), valid: true},
+		{name: "zone", rr: rng([]float64{0.5, 0.25}, []float64{0.75, 0.5}), valid: true},
+		{name: "no coordinates", rr: rng(nil, nil)},
+		{name: "too few coordinates", rr: rng([]float64{0}, []float64{1})},
+		{name: "too many coordinates", rr: rng([]float64{0, 0, 0}, []float64{1, 1, 1})},
+		{name: "points of different lengths", rr: rng([]float64{0, 0}, []float64{1})},
+		{name: "empty", rr: rng([]float64{0.5, 0}, []float64{0.5, 1})},
+		{name: "inverted", rr: rng([]float64{0.75, 0}, []float64{0.25, 1})},
+		{name: "below the space", rr: rng([]float64{-0.5, 0}, []float64{0.5, 1})},
+		{name: "above the space", rr: rng([]float64{0.5, 0}, []float64{1.5, 1})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckRange(tt.rr, 2); (err == nil) != tt.valid {
+				t.Fatalf("CheckRange returned %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestRangeNeighbors(t *testing.T) {
+	box := func(coords ...float64) *Range {
+		half := len(coords) / 2
+		return &Range{P1: Point{coords[:half]}, P2: Point{coords[half:]}}
+	}
+
+	tests := []struct {
+		name      string
+		a, b      *Range
+		neighbors bool
+		torus     bool // Neighbors once the space wraps around
+	}{
+		{name: "shared face", a: box(0, 0, 0.5, 1), b: box(0.5, 0, 1, 1), neighbors: true, torus: true},
+		{name: "partly overlapping face", a: box(0, 0, 0.5, 0.5), b: box(0.5, 0.25, 1, 0.75), neighbors: true, torus: true},
+		{name: "face inside a larger face", a: box(0, 0.25, 0.5, 0.5), b: box(0.5, 0, 1, 1), neighbors: true, torus: true},
+		{name: "corners only", a: box(0, 0, 0.5, 0.5), b: box(0.5, 0.5, 1, 1)},
+		{name: "edges meeting at a point", a: box(0, 0, 0.5, 0.5), b: box(0.5, 0.5, 0.75, 0.75)},
+		{name: "apart", a: box(0, 0, 0.25, 1), b: box(0.5, 0, 0.75, 1)},
+		{name: "overlapping", a: box(0, 0, 0.75, 1), b: box(0.5, 0, 1, 1)},
+		{name: "same", a: box(0, 0, 0.5, 1), b: box(0, 0, 0.5, 1)},
+		{name: "across the edge of the space", a: box(0, 0, 0.25, 1), b: box(0.75, 0, 1, 1), torus: true},
+		{name: "across two edges", a: box(0, 0, 0.25, 0.25), b: box(0.75, 0.75, 1, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Neighbors(tt.b); got != tt.neighbors || tt.b.Neighbors(tt.a) != got {
+				t.Fatalf("Neighbors is %v, want %v", got, tt.neighbors)
+			}
+			if got := tt.a.NeighborsOnTorus(tt.b); got != tt.torus || tt.b.NeighborsOnTorus(tt.a) != got {
+				t.Fatalf("NeighborsOnTorus is %v, want %v", got, tt.torus)
+			}
+		})
+	}
+}
+
+func TestRangeNeighborsHighDimensions(t *testing.T) {
+	// Faces of 64 dimensional zones, which counting corners could never compare
+	const dim = 64
+	a, b, c := &Range{}, &Range{}, &Range{}
+	for i := 0; i < dim; i++ {
+		a.P1.Coords, a.P2.Coords = append(a.P1.Coords, 0), append(a.P2.Coords, 0.5)
+		b.P1.Coords, b.P2.Coords = append(b.P1.Coords, 0.25), append(b.P2.Coords, 0.75)
+		c.P1.Coords, c.P2.Coords = append(c.P1.Coords, 0), append(c.P2.Coords, 0.5)
+	}
+	b.P1.Coords[dim-1], b.P2.Coords[dim-1] = 0.5, 1
+	c.P1.Coords[0], c.P2.Coords[0] = 0.5, 1
+	c.P1.Coords[dim-1], c.P2.Coords[dim-1] = 0.5, 1
+
+	if !a.Neighbors(b) {
+		t.Error("Zones touching along the last axis and overlapping along the rest are not neighbors")
+	}
+	if a.Neighbors(c) {
+		t.Error("Zones touching along two axes are neighbors")
+	}
+}
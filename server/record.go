@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"main/data"
+	"strconv"
+	"time"
+)
+
+// Content types given to values which arrive without one
+const (
+	DefaultTextType   = "text/plain; charset=utf-8"
+	DefaultBinaryType = "application/octet-stream"
+)
+
+// Record - A stored value along with its metadata
+type Record struct {
+	Value       []byte    `json:"value"`
+	ContentType string    `json:"contentType"`
+	Version     uint64    `json:"version"`
+	Checksum    string    `json:"checksum"`
+	Created     time.Time `json:"created"`
+	Modified    time.Time `json:"modified"`
+	Expires     time.Time `json:"expires"` // Zero if the record never expires
+}
+
+// NewRecord - Create a record holding a value, stamping its checksum and creation time
+func NewRecord(value []byte, contentType string) Record {
+	now := time.Now().UTC()
+	sum := sha256.Sum256(value)
+	return Record{
+		Value:       value,
+		ContentType: contentType,
+		Checksum:    hex.EncodeToString(sum[:]),
+		Created:     now,
+		Modified:    now,
+	}
+}
+
+// Expired - Determine if the record has expired at a given time
+func (rec *Record) Expired(now time.Time) bool {
+	return !rec.Expires.IsZero() && !now.Before(rec.Expires)
+}
+
+// expiresPtr - Return the expiry for transmission, nil if the record never expires
+func (rec *Record) expiresPtr() *time.Time {
+	if rec.Expires.IsZero() {
+		return nil
+	}
+	expires := rec.Expires
+	return &expires
+}
+
+// Size - Return the length of the stored value in bytes
+func (rec *Record) Size() int {
+	return len(rec.Value)
+}
+
+// GetRecordResponse - Marshal a record into a transmittable JSON form
+func (rec *Record) GetRecordResponse() *data.RecordResponse {
+	rr := &data.RecordResponse{
+		Value:       rec.Value,
+		ContentType: rec.ContentType,
+		Version:     rec.Version,
+		Size:        rec.Size(),
+		Checksum:    rec.Checksum,
+		Created:     rec.Created,
+		Modified:    rec.Modified,
+		Expires:     rec.expiresPtr(),
+	}
+	return rr
+}
+
+// GetDataResponse - Fill in a DataResponse with a key, its record and a message
+func (rec *Record) GetDataResponse(key string, pt Point, msg string) *data.DataResponse {
+	dRes := &data.DataResponse{
+		Key:         key,
+		Data:        string(rec.Value),
+		ContentType: rec.ContentType,
+		Version:     rec.Version,
+		Size:        rec.Size(),
+		Checksum:    rec.Checksum,
+		Created:     rec.Created,
+		Modified:    rec.Modified,
+		Expires:     rec.expiresPtr(),
+		Coords:      pt.Coords,
+		Message:     msg,
+	}
+	return dRes
+}
+
+// UnpackRecord - Unmarshal a RecordResponse into a record
+func UnpackRecord(rr data.RecordResponse) Record {
+	rec := Record{
+		Value:       rr.Value,
+		ContentType: rr.ContentType,
+		Version:     rr.Version,
+		Checksum:    rr.Checksum,
+		Created:     rr.Created,
+		Modified:    rr.Modified,
+	}
+	if rr.Expires != nil {
+		rec.Expires = *rr.Expires
+	}
+	return rec
+}
+
+// UnpackData - Take a transmitted map of records into an appropriate map
+func UnpackData(dataMap map[string]data.RecordResponse) map[string]Record {
+	recMap := make(map[string]Record)
+	for key, rr := range dataMap {
+		recMap[key] = UnpackRecord(rr)
+	}
+	return recMap
+}
+
+// MaxTTL - Longest TTL in seconds a write may give, far short of where adding it to the time overflows
+const MaxTTL = int64(100 * 365 * 24 * time.Hour / time.Second)
+
+// ParseExpiry - Work out when a write should expire from a TTL in seconds or an absolute time
+//
+// A positive TTL or an absolute time sets the expiry, a negative TTL removes it, and
+// giving neither leaves keep set so an update can preserve the existing expiry.
+func ParseExpiry(ttl int64, at *time.Time) (expires time.Time, keep bool, err error) {
+	switch {
+	case ttl != 0 && at != nil:
+		return time.Time{}, false, errors.New("Only one of ttl and expiresAt may be given")
+	case ttl > MaxTTL:
+		return time.Time{}, false, errors.New("ttl must be at most " + strconv.FormatInt(MaxTTL, 10) + " seconds")
+	case ttl > 0:
+		return time.Now().UTC().Add(time.Duration(ttl) * time.Second), false, nil
+	case ttl < 0:
+		return time.Time{}, false, nil
+	case at != nil:
+		if !at.After(time.Now()) {
+			return time.Time{}, false, errors.New("expiresAt must be in the future")
+		}
+		return at.UTC(), false, nil
+	}
+	return time.Time{}, true, nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		ttl     int64
+		at      *time.Time
+		after   time.Duration // Least time from now the expiry must be, when one is set
+		none    bool          // Expiry is removed
+		keep    bool
+		invalid bool
+	}{
+		{name: "neither keeps the expiry", keep: true},
+		{name: "ttl", ttl: 60, after: 59 * time.Second},
+		{name: "negative ttl removes the expiry", ttl: -1, none: true},
+		{name: "longest ttl", ttl: MaxTTL, after: time.Duration(MaxTTL-1) * time.Second},
+		{name: "ttl past the longest", ttl: MaxTTL + 1, invalid: true},
+		{name: "ttl which would overflow", ttl: 1 << 62, invalid: true},
+		{name: "absolute time", at: &future, after: 59 * time.Minute},
+		{name: "absolute time in the past", at: &past, invalid: true},
+		{name: "both", ttl: 60, at: &future, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expires, keep, err := ParseExpiry(tt.ttl, tt.at)
+			switch {
+			case tt.invalid:
+				if err == nil {
+					t.Fatalf("Accepted, expiring at %v", expires)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case keep != tt.keep:
+				t.Fatalf("Keep is %v, want %v", keep, tt.keep)
+			case tt.none || tt.keep:
+				if !expires.IsZero() {
+					t.Fatalf("Expires at %v, want no expiry", expires)
+				}
+			case time.Until(expires) < tt.after:
+				t.Fatalf("Expires in %v, want at least %v", time.Until(expires), tt.after)
+			}
+		})
+	}
+}
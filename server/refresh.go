@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"main/data"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Refresh - Send our zone and neighbor table to every neighbor, so lost membership messages are repaired
+func (s *Server) Refresh() {
+	me := s.Self(nil)
+	rr := &data.RefreshRequest{
+		ID:      me.ID,
+		Address: me.Host.String(),
+		Range:   *(me.Space.GetRangeResponse()),
+		Zone:    string(me.Zone),
+		Epoch:   me.Epoch,
+	}
+
+	neighbors := s.Reg.NeighborList()
+	for _, neighbor := range neighbors {
+		rr.Neighbors = append(rr.Neighbors, *(neighbor.GetNeighborResponse()))
+	}
+	body, _ := json.Marshal(rr)
+
+	for _, neighbor := range neighbors {
+		req, _ := s.newSignedRequest(neighbor.ID, http.MethodPost, neighbor.URL("/neighbors/refresh"), body)
+		resp, err := s.C.Do(req)
+		if err != nil {
+			log.Warn("Could not refresh neighbor " + neighbor.ID + ": " + err.Error())
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+// StartRefresh - Periodically refresh our neighbors until stop is closed
+func (s *Server) StartRefresh(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.Refresh()
+			}
+		}
+	}()
+}
+
+// RefreshNeighbor - Reconcile our neighbor table with a neighbor's periodic update
+func (s *Server) RefreshNeighbor(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered RefreshNeighbor method")
+
+	rr := data.RefreshRequest{}
+	err := json.NewDecoder(r.Body).Decode(&rr)
+	if err == nil {
+		err = CheckRange(rr.Range, s.Reg.Dimension)
+	}
+	for i := 0; err == nil && i < len(rr.Neighbors); i++ {
+		if err = CheckRange(rr.Neighbors[i].Range, s.Reg.Dimension); err != nil {
+			err = errors.New("Neighbor " + rr.Neighbors[i].ID + ": " + err.Error())
+		}
+	}
+	if err != nil {
+		log.Warn(err)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	sender := Neighbor{
+		ID:    rr.ID,
+		Host:  fillAddress(rr.Address, r),
+		Space: *UnpackRange(rr.Range),
+		Zone:  ZoneID(rr.Zone),
+		Epoch: rr.Epoch,
+	}
+	others := make([]Neighbor, 0, len(rr.Neighbors))
+	for _, nr := range rr.Neighbors {
+		others = append(others, Neighbor{
+			ID:    nr.ID,
+			Host:  ParseHost(nr.Address, ""),
+			Space: *UnpackRange(nr.Range),
+			Zone:  ZoneID(nr.Zone),
+			Epoch: nr.Epoch,
+		})
+	}
+
+	added, updated, removed := s.Reg.Reconcile(s.ID, sender, others)
+	if len(added)+len(updated)+len(removed) > 0 {
+		log.WithFields(logrus.Fields{
+			"from":    rr.ID,
+			"added":   added,
+			"updated": updated,
+			"removed": removed,
+		}).Info("Repaired neighbor table")
+	}
+
+	log.Info("Exiting RefreshNeighbor method")
+}
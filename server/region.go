@@ -2,28 +2,109 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	"main/data"
 	"math"
+	"net"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Host - Contains identifying information for a CAN server host
 type Host struct {
-	IP   string `json:"ip"`
-	Port string `json:"port"`
+	IP     string `json:"ip"`
+	Port   string `json:"port"`
+	Scheme string `json:"scheme"` // Empty for plain HTTP
+}
+
+// ParseHost - Parse a host from "ip:port" or "scheme://ip:port", using defaultScheme if none is given
+func ParseHost(addr, defaultScheme string) Host {
+	scheme := defaultScheme
+	if i := strings.Index(addr, "://"); i >= 0 {
+		scheme, addr = addr[:i], addr[i+3:]
+	}
+	if scheme == "http" {
+		scheme = ""
+	}
+
+	// An address without a port is taken to be only an IP, IPv6 literals may be bracketed
+	ip, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		ip = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	}
+	return Host{
+		IP:     ip,
+		Port:   port,
+		Scheme: scheme,
+	}
+}
+
+// String - Format a host as "ip:port", prefixed with its scheme when it is not plain HTTP
+func (h Host) String() string {
+	addr := net.JoinHostPort(h.IP, h.Port)
+	if h.Scheme != "" {
+		return h.Scheme + "://" + addr
+	}
+	return addr
+}
+
+// URL - Build the URL of a path on a host
+func (h Host) URL(path string) string {
+	scheme := h.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + net.JoinHostPort(h.IP, h.Port) + path
+}
+
+// Neighbor - A neighboring CAN server, identified by its node ID, with its address and zone
+type Neighbor struct {
+	ID string
+	Host
+	Space Range
+	Zone  ZoneID // Split path of the neighbor's zone, empty if it did not send one
+	Epoch uint64 // Changes whenever the neighbor's zone changes, newer information has a larger epoch
+}
+
+// GetNeighborResponse - Marshal a neighbor into a transmittable JSON form
+func (n *Neighbor) GetNeighborResponse() *data.NeighborResponse {
+	nr := &data.NeighborResponse{
+		ID:      n.ID,
+		Address: n.Host.String(),
+		Range:   *(n.Space.GetRangeResponse()),
+		Zone:    string(n.Zone),
+		Epoch:   n.Epoch,
+	}
+	return nr
 }
 
 // Region - Contains all necessary information for a CAN server
 type Region struct {
-	Dimension  int               `json:"dimension"`
-	Redundancy int               `json:"redundancy"`
-	Space      Range             `json:"range"`
-	Data       map[string]string `json:"data"`
-	Neighbors  map[Host]Range    `json:"neighbords"`
+	Dimension     int                 `json:"dimension"`
+	Redundancy    int                 `json:"redundancy"`
+	Space         Range               `json:"range"`
+	Zone          ZoneID              `json:"zone"` // Split path of our zone, fixing its bounds exactly unless cuts are not halves
+	Data          map[string]Record   `json:"data"`
+	Tombstones    map[string]uint64   `json:"-"`         // Versions at which keys were deleted, so replicas do not bring them back
+	Neighbors     map[string]Neighbor `json:"neighbors"` // Keyed by node ID
+	Hasher        Hasher              `json:"-"`
+	Placement     string              `json:"placement"`
+	Torus         bool                `json:"torus"`   // Sides of the space wrap around to the opposite side
+	Epoch         uint64              `json:"epoch"`   // Changes whenever our zone changes
+	SplitStrategy string              `json:"split"`   // Strategy choosing where zones are cut
+	History       []SplitStep         `json:"history"` // Splits which produced our zone, oldest first
+
+	mu       sync.RWMutex // Guards Data against the background reaper, and the zone against splits
+	nmu      sync.RWMutex // Guards Neighbors against the background refresh
+	reserved *Region      // Half of the zone being handed to a joiner, guarded by mu
+	geo      atomic.Value // Snapshot of the zone last returned by geometry
 }
 
-// CreateRegion - Creates a region with a given number of dimensions and redundancy
-func CreateRegion(dim, red int) *Region {
+// CreateRegion - Creates a region with a given number of dimensions, redundancy, hasher and placement
+func CreateRegion(dim, red int, hasher Hasher, placement string) *Region {
 	// Create bounding points
 	p1 := new(Point)
 	p2 := new(Point)
@@ -39,193 +120,617 @@ func CreateRegion(dim, red int) *Region {
 
 	// Create the region
 	region := &Region{
-		Dimension:  dim,
-		Redundancy: red,
-		Space:      r,
-		Data:       make(map[string]string),
-		Neighbors:  make(map[Host]Range),
+		Dimension:     dim,
+		Redundancy:    red,
+		Space:         r,
+		Data:          make(map[string]Record),
+		Tombstones:    make(map[string]uint64),
+		Neighbors:     make(map[string]Neighbor),
+		Hasher:        hasher,
+		Placement:     placement,
+		SplitStrategy: DefaultSplit,
 	}
 
 	return region
 }
 
-// UnpackNeighbors - Take a transmitted map of neighbor information into an appropriate map
-func UnpackNeighbors(neighMap map[string]data.RangeResponse) map[Host]Range {
-	hostMap := make(map[Host]Range)
+// HashKey - Map a key to its point in this region's space, keeping key order along one axis under ordered placement
+func (r *Region) HashKey(key string) Point {
+	pt := r.Hasher.HashToPoint(key, r.Dimension)
+	if r.Placement == PlacementOrdered {
+		pt.Coords[OrderedAxis] = orderKey(key)
+	}
+	return pt
+}
 
-	for hst, rng := range neighMap {
-		hostInfo := strings.Split(hst, ":")
-		host := &Host{
-			IP:   hostInfo[0],
-			Port: hostInfo[1],
+// UnpackNeighbors - Take a transmitted map of neighbor information into an appropriate map
+func UnpackNeighbors(neighMap map[string]data.NeighborResponse) map[string]Neighbor {
+	idMap := make(map[string]Neighbor)
+
+	for id, nr := range neighMap {
+		idMap[id] = Neighbor{
+			ID:    id,
+			Host:  ParseHost(nr.Address, ""),
+			Space: *UnpackRange(nr.Range),
+			Zone:  ZoneID(nr.Zone),
+			Epoch: nr.Epoch,
 		}
-		hostMap[*host] = *UnpackRange(rng)
 	}
 
-	return hostMap
+	return idMap
 }
 
+// Errors returned when operating on data within a region
+var (
+	ErrNotInRange         = errors.New("Point not in range")
+	ErrKeyNotFound        = errors.New("Key does not exist in map")
+	ErrKeyExists          = errors.New("Key already exists in map")
+	ErrPreconditionFailed = errors.New("Precondition failed, record version does not match")
+	ErrSplitPending       = errors.New("Zone is being handed to a joining server, retry shortly")
+	ErrNeighborExists     = errors.New("Neighbor already exists in map")
+)
+
 // DeleteData - Remove data from within the region
-func (r *Region) DeleteData(pt Point, key string) (bool, string, error) {
+func (r *Region) DeleteData(pt Point, key string, cond Precondition) (bool, Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	// Ensure that the point is in this range
-	if !r.Space.PointInRange(pt) {
-		return false, "", errors.New("Point not in range")
+	if !r.geometry().Owns(pt) {
+		return false, Record{}, ErrNotInRange
+	}
+	if r.isReserved(pt) {
+		return false, Record{}, ErrSplitPending
+	}
+
+	// Locate the key, treating expired data as missing but still leaving a tombstone so replicas which
+	// have not expired it yet cannot bring it back
+	rec, prs := r.Data[key]
+	if prs && rec.Expired(time.Now()) {
+		delete(r.Data, key)
+		r.Tombstones[key] = nextVersion(rec.Version)
+		prs = false
+	}
+	if !cond.Check(&rec, prs) {
+		return false, Record{}, ErrPreconditionFailed
 	}
 
-	// Locate and remove the key if it exists, otherwise return error
-	datum, prs := r.Data[key]
-	delete(r.Data, key)
+	// Remove the key if it exists, otherwise return error
 	if prs {
-		return true, datum, nil
+		delete(r.Data, key)
+		r.Tombstones[key] = nextVersion(rec.Version)
+		return true, rec, nil
 	}
 
-	return false, "", errors.New("Key does not exist in map")
+	return false, Record{}, ErrKeyNotFound
 }
 
 // GetData - Retrieve data from within the region
-func (r *Region) GetData(pt Point, key string) (bool, string, error) {
+func (r *Region) GetData(pt Point, key string) (bool, Record, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	// Ensure that the point is in this range
-	if !r.Space.PointInRange(pt) {
-		return false, "", errors.New("Point not in range")
+	if !r.geometry().Owns(pt) {
+		return false, Record{}, ErrNotInRange
 	}
 
-	// Find the key if it exists in this region, otherwise return error
-	datum, prs := r.Data[key]
-	if prs {
-		return true, datum, nil
+	// Find the key if it exists in this region and has not expired, otherwise return error
+	rec, prs := r.Data[key]
+	if prs && !rec.Expired(time.Now()) {
+		return true, rec, nil
 	}
 
-	return false, "", errors.New("Key does not exist in map")
+	return false, Record{}, ErrKeyNotFound
 }
 
+// Modes for writing data into a region
+const (
+	writeAdd    = iota // Key must not exist
+	writeModify        // Key must exist
+	writeUpsert        // Key may or may not exist
+)
+
 // AddData - Add data to the region
-func (r *Region) AddData(pt Point, key, val string) (bool, error) {
-	// Ensure that the point is in this range
-	if !r.Space.PointInRange(pt) {
-		return false, errors.New("Point not in range")
-	}
+func (r *Region) AddData(pt Point, key string, rec *Record, cond Precondition) (bool, error) {
+	return r.writeData(pt, key, rec, writeAdd, false, cond)
+}
 
-	// If the key exists in this region, return an error
-	_, prs := r.Data[key]
-	if prs {
-		return false, errors.New("Key already exists in map")
-	}
+// ModifyData - Modify a value within a region, keeping its creation time and optionally its expiry
+func (r *Region) ModifyData(pt Point, key string, rec *Record, keepExpiry bool, cond Precondition) (bool, error) {
+	return r.writeData(pt, key, rec, writeModify, keepExpiry, cond)
+}
 
-	r.Data[key] = val
-	return true, nil
+// UpsertData - Add a value to the region, replacing any existing value for the key
+func (r *Region) UpsertData(pt Point, key string, rec *Record, cond Precondition) (bool, error) {
+	return r.writeData(pt, key, rec, writeUpsert, false, cond)
 }
 
-// ModifyData - Modify a value within a region
-func (r *Region) ModifyData(pt Point, key, val string) (bool, error) {
+// writeData - Store a record if the key's existence suits the mode and the precondition holds,
+// giving it the next version of the key
+func (r *Region) writeData(pt Point, key string, rec *Record, mode int, keepExpiry bool, cond Precondition) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	// Ensure that the point is in this range
-	if !r.Space.PointInRange(pt) {
-		return false, errors.New("Point not in range")
+	if !r.geometry().Owns(pt) {
+		return false, ErrNotInRange
+	}
+	if r.isReserved(pt) {
+		return false, ErrSplitPending
 	}
 
-	// If the key does not exist in this region, return an error
-	_, prs := r.Data[key]
-	if !prs {
-		return false, errors.New("Key not found in map, cannot modify data")
+	// Expired data only counts towards the version of the key
+	old, prs := r.Data[key]
+	live := prs && !old.Expired(time.Now())
+
+	if !cond.Check(&old, live) {
+		return false, ErrPreconditionFailed
+	}
+	if mode == writeAdd && live {
+		return false, ErrKeyExists
+	}
+	if mode == writeModify && !live {
+		return false, fmt.Errorf("%w, cannot modify data", ErrKeyNotFound)
 	}
 
-	r.Data[key] = val
+	if live {
+		rec.Created = old.Created
+		if keepExpiry {
+			rec.Expires = old.Expires
+		}
+	}
+	// A deleted key comes back with a version newer than its deletion
+	prev := old.Version
+	if tomb := r.Tombstones[key]; tomb > prev {
+		prev = tomb
+	}
+	rec.Version = nextVersion(prev)
+	r.Data[key] = *rec
+	delete(r.Tombstones, key)
 	return true, nil
 }
 
 // Locate - Determine if a point is within a region, return the closest nighbor if not
-func (r *Region) Locate(pt Point) (bool, *Host) {
-	for i, val := range pt.Coords {
-		// If any of the point's dimensions are outside our bounds, find a neighbor
-		if val < r.Space.P1.Coords[i] || val > r.Space.P2.Coords[i] {
-			host := r.findNearestNeighbor(pt)
-			return false, host
-		}
+func (r *Region) Locate(pt Point) (bool, *Neighbor) {
+	// If the point is outside our zone, find a neighbor
+	if !r.Owns(pt) {
+		host := r.findNearestNeighbor(pt)
+		return false, host
 	}
 
 	// Return true since the point is in our bounds
 	return true, nil
 }
 
+// GetDataResponse - Marshal all data in the region into a transmittable JSON form
+func (r *Region) GetDataResponse() map[string]data.RecordResponse {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	dr := make(map[string]data.RecordResponse)
+	for key, rec := range r.Data {
+		dr[key] = *(rec.GetRecordResponse())
+	}
+	return dr
+}
+
 // GetNeighborResponse - Marshal neighbor information into a transmittable JSON form
-func (r *Region) GetNeighborResponse() map[string]data.RangeResponse {
-	nr := make(map[string]data.RangeResponse)
-	for host, rng := range r.Neighbors {
-		nr[host.IP+":"+host.Port] = *(rng.GetRangeResponse())
+func (r *Region) GetNeighborResponse() map[string]data.NeighborResponse {
+	r.nmu.RLock()
+	defer r.nmu.RUnlock()
+
+	nr := make(map[string]data.NeighborResponse)
+	for id, neighbor := range r.Neighbors {
+		nr[id] = *(neighbor.GetNeighborResponse())
 	}
 	return nr
 }
 
 // findNearestNeighbor - Find an appropriate neighbor to forward data
-func (r *Region) findNearestNeighbor(pt Point) *Host {
+func (r *Region) findNearestNeighbor(pt Point) *Neighbor {
+	r.nmu.RLock()
+	defer r.nmu.RUnlock()
+
 	bestDist := math.Sqrt(float64(r.Dimension))
-	bestHost := new(Host)
+	bestNeighbor := new(Neighbor)
 
-	for host, ran := range r.Neighbors {
+	for _, neighbor := range r.Neighbors {
 		// If the point is in a known neighbor, return that neighbor
-		if ran.PointInRange(pt) {
-			return &host
+		if neighbor.Space.PointInRange(pt) {
+			return &neighbor
 		}
 
 		// Determine which neighbor's midpoint is closest to the point
-		dist := pt.Dist(*ran.P1.Midpoint(ran.P2))
+		dist := r.Dist(pt, *neighbor.Space.P1.Midpoint(neighbor.Space.P2))
 		if dist < bestDist {
 			bestDist = dist
-			*bestHost = host
+			*bestNeighbor = neighbor
 		}
 	}
 
-	return bestHost
+	return bestNeighbor
 }
 
-// AddNeighbor - Add neighbor to region
-func (r *Region) AddNeighbor(hostname, port string, rng Range) error {
-	host := Host{
-		IP:   hostname,
-		Port: port,
+// alternateNeighbor - Find the neighbor closest to a point among those not yet tried which are closer to
+// it than we are, so rerouting never sends a request backwards, nil if there is none
+func (r *Region) alternateNeighbor(pt Point, tried map[string]bool) *Neighbor {
+	space := r.Geometry().Space
+
+	r.nmu.RLock()
+	defer r.nmu.RUnlock()
+
+	bestDist := r.Dist(pt, *space.P1.Midpoint(space.P2))
+	var best *Neighbor
+	for _, neighbor := range r.Neighbors {
+		if tried[neighbor.ID] {
+			continue
+		}
+		dist := r.Dist(pt, *neighbor.Space.P1.Midpoint(neighbor.Space.P2))
+		if neighbor.Space.PointInRange(pt) {
+			dist = 0
+		}
+		if dist < bestDist {
+			bestDist = dist
+			n := neighbor
+			best = &n
+		}
 	}
+	return best
+}
 
-	_, prs := r.Neighbors[host]
+// Adjacent - Determine if a range shares a face with this region, wrapping around on a torus
+func (r *Region) Adjacent(other *Range) bool {
+	space := r.Geometry().Space
+	if r.Torus {
+		return space.NeighborsOnTorus(other)
+	}
+	return space.Neighbors(other)
+}
+
+// Dist - Return the distance between two points, wrapping around on a torus
+func (r *Region) Dist(a, b Point) float64 {
+	if r.Torus {
+		return a.TorusDist(b)
+	}
+	return a.Dist(b)
+}
+
+// AddNeighbor - Add neighbor to region
+func (r *Region) AddNeighbor(neighbor Neighbor) error {
+	r.nmu.Lock()
+	defer r.nmu.Unlock()
+
+	_, prs := r.Neighbors[neighbor.ID]
 	if prs {
-		return errors.New("Neighbor already exists in map")
+		return ErrNeighborExists
 	}
 
-	r.Neighbors[host] = rng
+	r.Neighbors[neighbor.ID] = neighbor
 	return nil
 }
 
+// UpdateNeighbor - Replace what we know of an existing neighbor, unless we already know something newer
+func (r *Region) UpdateNeighbor(neighbor Neighbor) error {
+	r.nmu.Lock()
+	defer r.nmu.Unlock()
+
+	old, prs := r.Neighbors[neighbor.ID]
+	if !prs {
+		return errors.New("Node " + neighbor.ID + " does not exist in neighbor map")
+	}
+	if neighbor.Epoch < old.Epoch {
+		return errors.New("Update for node " + neighbor.ID + " is older than the one we hold")
+	}
+
+	r.Neighbors[neighbor.ID] = neighbor
+	return nil
+}
+
+// RemoveNeighbor - Remove a neighbor from the region, returning false if it was not present
+func (r *Region) RemoveNeighbor(id string) bool {
+	r.nmu.Lock()
+	defer r.nmu.Unlock()
+
+	_, prs := r.Neighbors[id]
+	delete(r.Neighbors, id)
+	return prs
+}
+
+// neighborZone - The zone a neighbor holds as far as we know, false if it is not our neighbor
+func (r *Region) neighborZone(id string) (Range, bool) {
+	r.nmu.RLock()
+	defer r.nmu.RUnlock()
+	neighbor, prs := r.Neighbors[id]
+	return neighbor.Space, prs
+}
+
+// NeighborList - Return a snapshot of the region's neighbors, ordered by node ID
+func (r *Region) NeighborList() []Neighbor {
+	r.nmu.RLock()
+	defer r.nmu.RUnlock()
+
+	list := make([]Neighbor, 0, len(r.Neighbors))
+	for _, neighbor := range r.Neighbors {
+		list = append(list, neighbor)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Reconcile - Merge a neighbor's periodic update into our neighbor table, returning the IDs of
+// neighbors added, updated and removed
+//
+// The sender is authoritative about itself. Entries it passes on for other servers only replace ours
+// when their epoch is newer, and only add servers whose zones border ours. Afterwards, any entry whose
+// zone no longer borders ours is dropped.
+func (r *Region) Reconcile(selfID string, sender Neighbor, others []Neighbor) (added, updated, removed []string) {
+	// Take the zone before the neighbors, splits lock them in the other order
+	geo := r.Geometry()
+
+	r.nmu.Lock()
+	defer r.nmu.Unlock()
+
+	merge := func(n Neighbor, authoritative bool) {
+		old, prs := r.Neighbors[n.ID]
+		switch {
+		case !prs && geo.Borders(n.Zone, &n.Space):
+			r.Neighbors[n.ID] = n
+			added = append(added, n.ID)
+		case prs && (authoritative || n.Epoch > old.Epoch):
+			if n.Epoch != old.Epoch || n.Host != old.Host || n.Zone != old.Zone || !n.Space.Equal(&old.Space) {
+				r.Neighbors[n.ID] = n
+				updated = append(updated, n.ID)
+			}
+		}
+	}
+
+	merge(sender, true)
+	for _, n := range others {
+		if n.ID != selfID && n.ID != sender.ID {
+			merge(n, false)
+		}
+	}
+
+	for id, n := range r.Neighbors {
+		if !geo.Borders(n.Zone, &n.Space) {
+			delete(r.Neighbors, id)
+			removed = append(removed, id)
+		}
+	}
+	return added, updated, removed
+}
+
+// SplitPlan - A split of a region worked out ahead of time, applied once the joiner has its half
+type SplitPlan struct {
+	NewReg  *Region     // The joiner's half, holding copies of its data and neighbors
+	Space   Range       // Our zone once the split is applied
+	Zone    ZoneID      // Our zone ID once the split is applied
+	Epoch   uint64      // Our epoch once the split is applied
+	History []SplitStep // Our split history once the split is applied
+	Joiner  *Neighbor   // Added to our neighbors when the split is applied, if set
+}
+
 // Split - Split region into two halves, dividing data, neighbors, and space, returning the new region
-func (r *Region) Split(myHost string) (*Region, []Host) {
-	newRange := r.Space.Split()
+// and the neighbors which no longer border this region
+func (r *Region) Split(me Neighbor) (*Region, []Neighbor) {
+	plan, err := r.PlanSplit(me)
+	if err != nil {
+		return nil, nil
+	}
+	return plan.NewReg, r.ApplySplit(plan)
+}
 
-	newReg := &Region{
-		Dimension:  r.Dimension,
-		Redundancy: r.Redundancy,
-		Space:      *newRange,
-		Data:       make(map[string]string),
-		Neighbors:  make(map[Host]Range),
+// PlanSplit - Work out how to split the region in two and reserve the half handed to a joiner, leaving
+// the region itself unchanged
+//
+// Until the plan is applied or cancelled, writes to the reserved half fail with ErrSplitPending,
+// so the copy of its data handed to the joiner stays current.
+func (r *Region) PlanSplit(me Neighbor) (*SplitPlan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reserved != nil {
+		return nil, ErrSplitPending
 	}
 
+	axis, at := r.chooseSplit()
+	kept := r.Space.Copy()
+	newRange := kept.SplitAt(axis, at)
+
+	// Halves take their bounds from their zone IDs, so they stay exact however deep the splits go
+	keptZone, newZone := r.Zone.Child(false), r.Zone.Child(true)
+	if rng, ok := ZoneRange(keptZone, r.Dimension, r.SplitStrategy); ok {
+		kept = rng
+		newRange, _ = ZoneRange(newZone, r.Dimension, r.SplitStrategy)
+	}
+	step := SplitStep{Axis: axis, At: at, Lo: r.Space.P1.Coords[axis], Hi: r.Space.P2.Coords[axis]}
+
+	newReg := &Region{
+		Dimension:     r.Dimension,
+		Redundancy:    r.Redundancy,
+		Space:         *newRange,
+		Zone:          newZone,
+		Data:          make(map[string]Record),
+		Tombstones:    make(map[string]uint64),
+		Neighbors:     make(map[string]Neighbor),
+		Hasher:        r.Hasher,
+		Placement:     r.Placement,
+		Torus:         r.Torus,
+		SplitStrategy: r.SplitStrategy,
+		History:       append(append([]SplitStep(nil), r.History...), step),
+	}
+	newReg.History[len(newReg.History)-1].Upper = true
+	newGeo := newReg.Geometry()
 	for key, val := range r.Data {
-		if pt := HashStringToPoint(key, r.Dimension); newRange.PointInRange(pt) {
+		if pt := r.HashKey(key); newGeo.Owns(pt) {
 			newReg.Data[key] = val
-			delete(r.Data, key)
 		}
 	}
+	for key, version := range r.Tombstones {
+		if pt := r.HashKey(key); newGeo.Owns(pt) {
+			newReg.Tombstones[key] = version
+		}
+	}
+
+	// Both halves are new zones, so information about them supersedes anything held about the old one
+	plan := &SplitPlan{
+		NewReg:  newReg,
+		Space:   *kept,
+		Zone:    keptZone,
+		Epoch:   nextVersion(r.Epoch),
+		History: append(append([]SplitStep(nil), r.History...), step),
+	}
+	newReg.Epoch = nextVersion(0)
+
+	me.Space = *kept.Copy()
+	me.Zone = keptZone
+	me.Epoch = plan.Epoch
+	newReg.AddNeighbor(me)
+
+	r.nmu.RLock()
+	for id, neighbor := range r.Neighbors {
+		if newReg.Borders(neighbor.Zone, &neighbor.Space) {
+			newReg.Neighbors[id] = neighbor
+		}
+	}
+	r.nmu.RUnlock()
 
-	delHosts := make([]Host, 0)
+	r.reserved = newReg
+	return plan, nil
+}
 
-	myHostSplit := strings.Split(myHost, ":")
-	newReg.AddNeighbor(myHostSplit[0], myHostSplit[1], r.Space)
+// ApplySplit - Shrink the region to the zone a plan keeps, dropping the data handed to the joiner, and
+// return the neighbors which no longer border this region
+func (r *Region) ApplySplit(plan *SplitPlan) []Neighbor {
+	// Know the joiner before giving up its half, so requests for that half are never stranded
+	if plan.Joiner != nil {
+		r.nmu.Lock()
+		r.Neighbors[plan.Joiner.ID] = *plan.Joiner
+		r.nmu.Unlock()
+	}
 
-	for host, rng := range r.Neighbors {
-		if newRange.Neighbors(&rng) {
-			newReg.Neighbors[host] = rng
+	r.mu.Lock()
+	r.Space = *plan.Space.Copy()
+	r.Zone = plan.Zone
+	r.Epoch = plan.Epoch
+	r.History = plan.History
+	geo := r.geometry()
+	for key := range r.Data {
+		if !geo.Owns(r.HashKey(key)) {
+			delete(r.Data, key)
+		}
+	}
+	for key := range r.Tombstones {
+		if !geo.Owns(r.HashKey(key)) {
+			delete(r.Tombstones, key)
 		}
-		if !r.Space.Neighbors(&rng) {
-			delHosts = append(delHosts, host)
-			delete(r.Neighbors, host)
+	}
+	r.reserved = nil
+	r.mu.Unlock()
+
+	delNeighbors := make([]Neighbor, 0)
+
+	r.nmu.Lock()
+	defer r.nmu.Unlock()
+	for id, neighbor := range r.Neighbors {
+		if !geo.Borders(neighbor.Zone, &neighbor.Space) {
+			delNeighbors = append(delNeighbors, neighbor)
+			delete(r.Neighbors, id)
+		}
+	}
+	sort.Slice(delNeighbors, func(i, j int) bool { return delNeighbors[i].ID < delNeighbors[j].ID })
+
+	return delNeighbors
+}
+
+// takeZone - Replace the region's zone, data and neighbors with another region's, returning a region
+// holding the ones replaced, so handlers reading the region while a joiner takes up its zone see either
+// the old zone or the new one
+func (r *Region) takeZone(src *Region) *Region {
+	old := &Region{
+		Dimension:     r.Dimension,
+		Redundancy:    r.Redundancy,
+		Hasher:        r.Hasher,
+		Placement:     r.Placement,
+		Torus:         r.Torus,
+		SplitStrategy: r.SplitStrategy,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old.Space, r.Space = r.Space, src.Space
+	old.Zone, r.Zone = r.Zone, src.Zone
+	old.Epoch, r.Epoch = r.Epoch, src.Epoch
+	old.History, r.History = r.History, src.History
+	old.Data, r.Data = r.Data, src.Data
+	old.Tombstones, r.Tombstones = r.Tombstones, src.Tombstones
+
+	r.nmu.Lock()
+	defer r.nmu.Unlock()
+	old.Neighbors, r.Neighbors = r.Neighbors, src.Neighbors
+	return old
+}
+
+// CancelSplit - Release the half of the region reserved by a plan, keeping the whole zone
+func (r *Region) CancelSplit(plan *SplitPlan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reserved = nil
+}
+
+// isReserved - Determine if a point lies in a half reserved for a joiner, the caller must hold the lock
+func (r *Region) isReserved(pt Point) bool {
+	return r.reserved != nil && r.reserved.Owns(pt)
+}
+
+// ScanBounds - Lexicographic bounds on the keys returned by a scan
+type ScanBounds struct {
+	Start  string // Smallest key to return
+	After  string // Only return keys greater than this cursor, if set
+	End    string // Only return keys less than this, unbounded if empty
+	Prefix string // Only return keys with this prefix
+}
+
+// Contains - Determine if a key falls within the scan bounds
+func (b *ScanBounds) Contains(key string) bool {
+	return key >= b.Start &&
+		(b.After == "" || key > b.After) &&
+		(b.End == "" || key < b.End) &&
+		strings.HasPrefix(key, b.Prefix)
+}
+
+// ScanData - Return the limit smallest keys of the region's data within the scan bounds, sorted by key
+func (r *Region) ScanData(bounds ScanBounds, limit int) []data.ScanItem {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	items := []data.ScanItem{}
+	for key, rec := range r.Data {
+		if bounds.Contains(key) && !rec.Expired(now) {
+			items = append(items, data.ScanItem{Key: key, Data: string(rec.Value), ContentType: rec.ContentType})
 		}
 	}
 
-	return newReg, delHosts
+	sortScanItems(items)
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// ReapExpired - Remove all data which has expired by now, returning the removed keys
+func (r *Region) ReapExpired(now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reaped := []string{}
+	for key, rec := range r.Data {
+		if rec.Expired(now) {
+			delete(r.Data, key)
+			reaped = append(reaped, key)
+		}
+	}
+	return reaped
 }
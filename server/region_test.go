@@ -0,0 +1,30 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeleteExpiredLeavesTombstone(t *testing.T) {
+	hasher, _ := GetHasher(DefaultHasher)
+	reg := CreateRegion(2, 1, hasher, PlacementHashed)
+	pt := reg.HashKey("key")
+
+	rec := NewRecord([]byte("value"), DefaultTextType)
+	rec.Expires = time.Now().Add(time.Hour)
+	if _, err := reg.AddData(pt, "key", &rec, Precondition{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replicas which have not expired the key yet must not bring it back
+	stored := reg.Data["key"]
+	stored.Expires = time.Now().Add(-time.Second)
+	reg.Data["key"] = stored
+	if _, _, err := reg.DeleteData(pt, "key", Precondition{}); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Deleting an expired key failed with %v, want %v", err, ErrKeyNotFound)
+	}
+	if tomb := reg.Tombstones["key"]; tomb <= rec.Version {
+		t.Fatalf("Tombstone has version %d, want one past %d", tomb, rec.Version)
+	}
+}
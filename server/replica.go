@@ -0,0 +1,551 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"main/data"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxMerkleDepth - Deepest Merkle tree a replica will build for a peer
+const maxMerkleDepth = 16
+
+// TombstoneTTL - How long deleted keys are remembered, replicas must exchange at least once in this time
+const TombstoneTTL = time.Hour
+
+// Entry - The state of one key exchanged between replicas, a tombstone holding only the version
+// of the deletion if the key was deleted
+type Entry struct {
+	Key     string
+	Record  Record
+	Deleted bool
+}
+
+// Supersedes - Determine if an entry should replace another for the same key
+//
+// The larger version wins. A deletion beats a write of the same version, and otherwise
+// the larger checksum breaks ties, so every replica settles on the same entry.
+func (e *Entry) Supersedes(other *Entry) bool {
+	if e.Record.Version != other.Record.Version {
+		return e.Record.Version > other.Record.Version
+	}
+	if e.Deleted != other.Deleted {
+		return e.Deleted
+	}
+	return e.Record.Checksum > other.Record.Checksum
+}
+
+// GetReplicaEntry - Marshal an entry into a transmittable JSON form
+func (e *Entry) GetReplicaEntry() data.ReplicaEntry {
+	return data.ReplicaEntry{
+		Key:     e.Key,
+		Record:  *(e.Record.GetRecordResponse()),
+		Deleted: e.Deleted,
+	}
+}
+
+// UnpackEntries - Take transmitted replica entries into entries
+func UnpackEntries(res []data.ReplicaEntry) []Entry {
+	entries := make([]Entry, 0, len(res))
+	for _, re := range res {
+		entries = append(entries, Entry{Key: re.Key, Record: UnpackRecord(re.Record), Deleted: re.Deleted})
+	}
+	return entries
+}
+
+// packEntries - Marshal entries into a transmittable JSON form
+func packEntries(entries []Entry) []data.ReplicaEntry {
+	res := make([]data.ReplicaEntry, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e.GetReplicaEntry())
+	}
+	return res
+}
+
+// Entries - Return every live key and tombstone held by the region
+func (r *Region) Entries() []Entry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	entries := make([]Entry, 0, len(r.Data)+len(r.Tombstones))
+	for key, rec := range r.Data {
+		if !rec.Expired(now) {
+			entries = append(entries, Entry{Key: key, Record: rec})
+		}
+	}
+	for key, version := range r.Tombstones {
+		entries = append(entries, Entry{Key: key, Record: Record{Version: version}, Deleted: true})
+	}
+	return entries
+}
+
+// Entry - Return the entry held for a key, false if the region knows nothing of it
+func (r *Region) Entry(key string) (Entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.entry(key, time.Now())
+}
+
+// entry - Look up the entry for a key, the caller must hold the lock
+func (r *Region) entry(key string, now time.Time) (Entry, bool) {
+	if rec, prs := r.Data[key]; prs && !rec.Expired(now) {
+		return Entry{Key: key, Record: rec}, true
+	}
+	if version, prs := r.Tombstones[key]; prs {
+		return Entry{Key: key, Record: Record{Version: version}, Deleted: true}, true
+	}
+	return Entry{}, false
+}
+
+// MergeEntry - Apply an entry from a replica if it supersedes ours, returning true if it was applied
+//
+// Entries for keys outside the region, in a half reserved for a joiner, and expired records are ignored.
+func (r *Region) MergeEntry(e Entry) bool {
+	pt := r.HashKey(e.Key)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.geometry().Owns(pt) {
+		return false
+	}
+	// The joiner takes the reserved half as it was planned, and syncs with its own partners afterwards
+	if r.isReserved(pt) {
+		return false
+	}
+
+	now := time.Now()
+	if !e.Deleted && e.Record.Expired(now) {
+		return false
+	}
+	if old, prs := r.entry(e.Key, now); prs && !e.Supersedes(&old) {
+		return false
+	}
+
+	if e.Deleted {
+		delete(r.Data, e.Key)
+		r.Tombstones[e.Key] = e.Record.Version
+	} else {
+		r.Data[e.Key] = e.Record
+		delete(r.Tombstones, e.Key)
+	}
+	return true
+}
+
+// PurgeTombstones - Forget deletions made before a given time, returning how many were forgotten
+func (r *Region) PurgeTombstones(before time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for key, version := range r.Tombstones {
+		if version < uint64(before.UnixNano()) {
+			delete(r.Tombstones, key)
+			purged++
+		}
+	}
+	return purged
+}
+
+// ReplicaStore - Copies of other servers' data held on their behalf, by owner node ID and key
+type ReplicaStore struct {
+	mu   sync.Mutex
+	sets map[string]map[string]Entry
+}
+
+// NewReplicaStore - Create an empty replica store
+func NewReplicaStore() *ReplicaStore {
+	return &ReplicaStore{sets: make(map[string]map[string]Entry)}
+}
+
+// Merge - Store an owner's entry if it supersedes the copy we hold, returning true if it was stored
+func (rs *ReplicaStore) Merge(owner string, e Entry) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	set, prs := rs.sets[owner]
+	if !prs {
+		set = make(map[string]Entry)
+		rs.sets[owner] = set
+	}
+	if old, prs := set[e.Key]; prs && !e.Supersedes(&old) {
+		return false
+	}
+	set[e.Key] = e
+	return true
+}
+
+// Entries - Return the entries held for an owner within its zone, dropping any which have left it
+func (rs *ReplicaStore) Entries(owner string, space *Range, hash func(string) Point) []Entry {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	now := time.Now()
+	set := rs.sets[owner]
+	entries := make([]Entry, 0, len(set))
+	for key, e := range set {
+		if !space.PointInRange(hash(key)) {
+			delete(set, key)
+			continue
+		}
+		if !e.Deleted && e.Record.Expired(now) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Reap - Remove expired records and tombstones older than TombstoneTTL from every owner's copies
+func (rs *ReplicaStore) Reap(now time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	before := uint64(now.Add(-TombstoneTTL).UnixNano())
+	for _, set := range rs.sets {
+		for key, e := range set {
+			if (e.Deleted && e.Record.Version < before) || (!e.Deleted && e.Record.Expired(now)) {
+				delete(set, key)
+			}
+		}
+	}
+}
+
+// Retain - Drop the copies of owners for which keep returns false, returning the dropped owners
+func (rs *ReplicaStore) Retain(keep func(owner string) bool) []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	dropped := []string{}
+	for owner := range rs.sets {
+		if !keep(owner) {
+			delete(rs.sets, owner)
+			dropped = append(dropped, owner)
+		}
+	}
+	return dropped
+}
+
+// Counts - Return how many entries are held for each owner
+func (rs *ReplicaStore) Counts() map[string]int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	counts := make(map[string]int)
+	for owner, set := range rs.sets {
+		counts[owner] = len(set)
+	}
+	return counts
+}
+
+// ReplicaPartners - The neighbors holding copies of our data, the first Redundancy-1 by node ID
+func (s *Server) ReplicaPartners() []Neighbor {
+	neighbors := s.Reg.NeighborList()
+	want := s.Reg.Redundancy - 1
+	if want <= 0 {
+		return nil
+	}
+	if len(neighbors) > want {
+		neighbors = neighbors[:want]
+	}
+	return neighbors
+}
+
+// replicate - Push the current entry for a key to our replica partners
+func (s *Server) replicate(key string) {
+	partners := s.ReplicaPartners()
+	if len(partners) == 0 {
+		return
+	}
+	e, prs := s.Reg.Entry(key)
+	if !prs {
+		return
+	}
+
+	push := &data.ReplicaPushRequest{Owner: s.ID, Entries: []data.ReplicaEntry{e.GetReplicaEntry()}}
+	for _, partner := range partners {
+		if err := s.postPeer(partner, "/replicas/push", push, nil); err != nil {
+			log.Warn("Could not replicate " + key + " to " + partner.ID + ", anti-entropy will repair it: " + err.Error())
+		}
+	}
+}
+
+// postPeer - Send a signed request to a neighboring server, decoding its verified response into res if given
+func (s *Server) postPeer(to Neighbor, path string, body interface{}, res interface{}) error {
+	reqBody, _ := json.Marshal(body)
+	req, err := s.newSignedRequest(to.ID, http.MethodPost, to.URL(path), reqBody)
+	if err != nil {
+		return err
+	}
+	resp, err := s.C.Do(req)
+	if err != nil {
+		return err
+	}
+	resBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		eRes := data.ErrorResponse{}
+		json.Unmarshal(resBody, &eRes)
+		return errors.New("status " + resp.Status + ": " + eRes.Message)
+	}
+	if res == nil {
+		return nil
+	}
+	if err := s.checkResponse(resp, resBody); err != nil {
+		return errors.New("response failed verification: " + err.Error())
+	}
+	return json.Unmarshal(resBody, res)
+}
+
+// SyncReplica - Compare Merkle trees with a replica partner and exchange only the keys in buckets
+// which differ, returning how many entries each side took from the other
+func (s *Server) SyncReplica(partner Neighbor) (sent, received int, err error) {
+	space := s.Reg.Geometry().Space
+	entries := s.Reg.Entries()
+	tree := BuildMerkleTree(entries, &space, s.Reg.HashKey, MerkleDepth)
+
+	mRes := data.MerkleResponse{}
+	mReq := &data.MerkleRequest{
+		Owner: s.ID,
+		Range: *(space.GetRangeResponse()),
+		Depth: MerkleDepth,
+		Root:  hex.EncodeToString(tree.Root()),
+	}
+	if err := s.postPeer(partner, "/replicas/tree", mReq, &mRes); err != nil {
+		return 0, 0, err
+	}
+	if mRes.InSync {
+		return 0, 0, nil
+	}
+	theirs, err := DecodeMerkleTree(mRes.Levels, MerkleDepth)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Only the keys in differing buckets are sent, along with the buckets to send back
+	buckets := tree.Diff(theirs)
+	if len(buckets) == 0 {
+		return 0, 0, nil
+	}
+	differ := make(map[int]bool)
+	for _, b := range buckets {
+		differ[b] = true
+	}
+	ours := []Entry{}
+	for _, e := range entries {
+		if differ[Bucket(s.Reg.HashKey(e.Key), &space, MerkleDepth)] {
+			ours = append(ours, e)
+		}
+	}
+
+	dRes := data.ReplicaDiffResponse{}
+	dReq := &data.ReplicaDiffRequest{
+		Owner:   s.ID,
+		Range:   mReq.Range,
+		Depth:   MerkleDepth,
+		Buckets: buckets,
+		Entries: packEntries(ours),
+	}
+	if err := s.postPeer(partner, "/replicas/diff", dReq, &dRes); err != nil {
+		return 0, 0, err
+	}
+
+	for _, e := range UnpackEntries(dRes.Entries) {
+		if s.Reg.MergeEntry(e) {
+			received++
+			if e.Deleted {
+				s.Watchers.Publish(EventDelete, e.Key, nil)
+			} else {
+				s.Watchers.Publish(EventPut, e.Key, &e.Record)
+			}
+		}
+	}
+	return len(ours), received, nil
+}
+
+// AntiEntropy - Run one exchange with each of our replica partners and drop copies held for servers
+// which are no longer our neighbors
+func (s *Server) AntiEntropy() {
+	for _, partner := range s.ReplicaPartners() {
+		sent, received, err := s.SyncReplica(partner)
+		if err != nil {
+			log.Warn("Could not sync replicas with " + partner.ID + ": " + err.Error())
+			continue
+		}
+		if sent+received > 0 {
+			log.WithFields(logrus.Fields{
+				"partner":  partner.ID,
+				"sent":     sent,
+				"received": received,
+			}).Info("Repaired replicas")
+		}
+	}
+
+	neighbors := make(map[string]bool)
+	for _, neighbor := range s.Reg.NeighborList() {
+		neighbors[neighbor.ID] = true
+	}
+	if dropped := s.Replicas.Retain(func(owner string) bool { return neighbors[owner] }); len(dropped) > 0 {
+		log.WithFields(logrus.Fields{
+			"owners": dropped,
+		}).Info("Dropped replicas of former neighbors")
+	}
+}
+
+// StartAntiEntropy - Periodically sync replicas with our partners until stop is closed
+func (s *Server) StartAntiEntropy(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.AntiEntropy()
+			}
+		}
+	}()
+}
+
+// parseReplicaRequest - Decode a replica request body, writing a Bad Request response on failure
+func parseReplicaRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		log.Warn(err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return false
+	}
+	return true
+}
+
+// checkMerkleDepth - Ensure a peer asked for a tree we are willing to build
+func checkMerkleDepth(w http.ResponseWriter, depth int) bool {
+	if depth < 0 || depth > maxMerkleDepth {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: fmt.Sprintf("Merkle depth must be between 0 and %d", maxMerkleDepth)})
+		return false
+	}
+	return true
+}
+
+// checkReplicaRange - Ensure the zone an owner compares its copies over fits our space and lies within the
+// zone we know it to hold, since buckets are cut from it
+//
+// An owner which has just grown is refused until its next refresh reaches us.
+func (s *Server) checkReplicaRange(w http.ResponseWriter, owner string, rr data.RangeResponse) bool {
+	err := CheckRange(rr, s.Reg.Dimension)
+	if err == nil {
+		if zone, prs := s.Reg.neighborZone(owner); !prs {
+			err = errors.New("Owner " + owner + " is not our neighbor")
+		} else if !UnpackRange(rr).Within(&zone) {
+			err = errors.New("Range lies outside the zone held by " + owner)
+		}
+	}
+	if err != nil {
+		log.Warn(err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return false
+	}
+	return true
+}
+
+// writeSigned - Write a signed JSON response
+func (s *Server) writeSigned(w http.ResponseWriter, res interface{}) {
+	resBody, _ := json.Marshal(res)
+	s.signResponse(w, resBody)
+	w.Write(resBody)
+}
+
+// PushReplica - Store entries an owner sent as they were written
+func (s *Server) PushReplica(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered PushReplica method")
+	w.Header().Add("Content-Type", "application/json")
+
+	pr := data.ReplicaPushRequest{}
+	if !parseReplicaRequest(w, r, &pr) {
+		return
+	}
+	for _, e := range UnpackEntries(pr.Entries) {
+		s.Replicas.Merge(pr.Owner, e)
+	}
+
+	log.Info("Exiting PushReplica method")
+}
+
+// ReplicaTree - Respond with the Merkle tree of the copies held for an owner, unless its root matches
+func (s *Server) ReplicaTree(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered ReplicaTree method")
+	w.Header().Add("Content-Type", "application/json")
+
+	mr := data.MerkleRequest{}
+	if !parseReplicaRequest(w, r, &mr) || !checkMerkleDepth(w, mr.Depth) || !s.checkReplicaRange(w, mr.Owner, mr.Range) {
+		return
+	}
+
+	space := UnpackRange(mr.Range)
+	tree := BuildMerkleTree(s.Replicas.Entries(mr.Owner, space, s.Reg.HashKey), space, s.Reg.HashKey, mr.Depth)
+
+	mRes := &data.MerkleResponse{InSync: hex.EncodeToString(tree.Root()) == mr.Root}
+	if !mRes.InSync {
+		mRes.Levels = tree.Encode()
+	}
+	s.writeSigned(w, mRes)
+
+	log.Info("Exiting ReplicaTree method")
+}
+
+// ReplicaDiff - Merge an owner's entries from differing buckets, responding with ours which it lacks
+// or which supersede its own
+func (s *Server) ReplicaDiff(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered ReplicaDiff method")
+	w.Header().Add("Content-Type", "application/json")
+
+	dr := data.ReplicaDiffRequest{}
+	if !parseReplicaRequest(w, r, &dr) || !checkMerkleDepth(w, dr.Depth) || !s.checkReplicaRange(w, dr.Owner, dr.Range) {
+		return
+	}
+	space := UnpackRange(dr.Range)
+
+	theirs := make(map[string]Entry)
+	for _, e := range UnpackEntries(dr.Entries) {
+		theirs[e.Key] = e
+		s.Replicas.Merge(dr.Owner, e)
+	}
+
+	wanted := make(map[int]bool)
+	for _, b := range dr.Buckets {
+		wanted[b] = true
+	}
+	back := []Entry{}
+	for _, e := range s.Replicas.Entries(dr.Owner, space, s.Reg.HashKey) {
+		if !wanted[Bucket(s.Reg.HashKey(e.Key), space, dr.Depth)] {
+			continue
+		}
+		if old, prs := theirs[e.Key]; !prs || e.Supersedes(&old) {
+			back = append(back, e)
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"owner":    dr.Owner,
+		"buckets":  len(dr.Buckets),
+		"received": len(dr.Entries),
+		"returned": len(back),
+	}).Debug("Exchanged replica entries")
+	s.writeSigned(w, &data.ReplicaDiffResponse{Entries: packEntries(back)})
+
+	log.Info("Exiting ReplicaDiff method")
+}
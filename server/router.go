@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// NewRouter - Route every endpoint of a CAN server to its handler
+func NewRouter(serv *Server) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Logger)
+	r.Use(keepSocketAddr)
+	r.Use(middleware.RealIP)
+
+	// Endpoints
+	r.Route("/", func(r chi.Router) {
+		// Join a CAN
+		r.With(serv.RequireClientCert, serv.RequireSignature).Post("/join", serv.Join)
+		r.With(serv.RequireClientCert, serv.RequireSignature).Post("/join/commit", serv.CommitJoin)
+
+		// Get info from CAN Server
+		r.Get("/healthz", serv.Healthz)
+		r.Get("/readyz", serv.Readyz)
+		r.Get("/cluster", serv.Cluster)
+		r.Get("/debug", serv.Debug)
+		r.With(serv.Admit).Post("/trace", serv.RouteTrace)
+		r.With(serv.RequireClientCert, serv.RequireSignature, serv.Admit).Get("/admin/verify", serv.VerifyCAN) // One request crawls the whole CAN
+
+		// Ordered range and prefix scans
+		r.With(serv.Admit).Get("/scan", serv.Scan)
+		r.With(serv.RequireClientCert, serv.RequireSignature).Get("/scan/zone", serv.ScanZone)
+
+		// Stream changes to data, outside admission since a watch holds its connection for as long as the
+		// client listens and would keep an in-flight slot with it, and key watches are redirected rather than
+		// forwarded so they never pass between servers
+		r.Get("/watch", serv.Watch)
+
+		// Interface with CAN Data
+		r.Route("/data", func(r chi.Router) {
+			r.Use(serv.Admit)
+			r.Put("/", serv.PutData)            // Add data
+			r.Patch("/", serv.PatchData)        // Update Data
+			r.Put("/{key}", serv.PutRawData)    // Add raw Data
+			r.Get("/{key}", serv.GetData)       // Retrieve Data
+			r.Delete("/{key}", serv.DeleteData) // Delete Data
+		})
+
+		// Interface with CAN Neighbors
+		r.Route("/neighbors", func(r chi.Router) {
+			r.Use(serv.RequireClientCert, serv.RequireSignature)
+			r.Put("/", serv.AddNeighbor)             // Add Neighbor
+			r.Patch("/", serv.PatchNeighbor)         // Update Neighbor
+			r.Delete("/", serv.DeleteNeighbor)       // Delete Neighbor
+			r.Post("/refresh", serv.RefreshNeighbor) // Reconcile with a neighbor's periodic update
+		})
+
+		// Keep copies of neighbors' data in sync
+		r.Route("/replicas", func(r chi.Router) {
+			r.Use(serv.RequireClientCert, serv.RequireSignature)
+			r.Post("/push", serv.PushReplica) // Store entries as they are written
+			r.Post("/tree", serv.ReplicaTree) // Compare Merkle trees
+			r.Post("/diff", serv.ReplicaDiff) // Exchange entries in differing buckets
+		})
+	})
+
+	r.Options("/*", serv.Options)
+	r.Options("/join", serv.JoinOptions)
+	r.Options("/data", serv.DataOptions)
+	r.Options("/healthz", serv.DebugOptions)
+	r.Options("/readyz", serv.DebugOptions)
+	r.Options("/cluster", serv.DebugOptions)
+	r.Options("/debug", serv.DebugOptions)
+	r.Options("/admin/verify", serv.DebugOptions)
+	r.Options("/scan", serv.ScanOptions)
+	r.Options("/watch", serv.WatchOptions)
+
+	return r
+}
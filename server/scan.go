@@ -0,0 +1,295 @@
+package server
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"main/data"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Limits on the number of items returned by a single scan
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+// zoneQueue - Priority queue of zones ordered by their lower bound along the ordered axis
+type zoneQueue []zoneEntry
+
+type zoneEntry struct {
+	id    string
+	host  Host
+	space Range
+	local bool
+}
+
+func (q zoneQueue) Len() int { return len(q) }
+func (q zoneQueue) Less(i, j int) bool {
+	return q[i].space.P1.Coords[OrderedAxis] < q[j].space.P1.Coords[OrderedAxis]
+}
+func (q zoneQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *zoneQueue) Push(x interface{}) { *q = append(*q, x.(zoneEntry)) }
+func (q *zoneQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// parseScanBounds - Read scan bounds and a limit from the query string of a scan request
+func parseScanBounds(query url.Values) (ScanBounds, int, error) {
+	bounds := ScanBounds{
+		Start:  query.Get("start"),
+		After:  query.Get("cursor"),
+		End:    query.Get("end"),
+		Prefix: query.Get("prefix"),
+	}
+
+	// A prefix narrows the bounds to the keys sharing it
+	if bounds.Prefix != "" {
+		if bounds.Start < bounds.Prefix {
+			bounds.Start = bounds.Prefix
+		}
+		if end := prefixEnd(bounds.Prefix); end != "" && (bounds.End == "" || end < bounds.End) {
+			bounds.End = end
+		}
+	}
+
+	limit := defaultScanLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			return bounds, 0, errors.New("Limit must be a positive integer")
+		}
+		if limit > maxScanLimit {
+			limit = maxScanLimit
+		}
+	}
+
+	return bounds, limit, nil
+}
+
+// lowerKey - The smallest key a scan can return, used to find where the walk starts
+func (b *ScanBounds) lowerKey() string {
+	if b.After > b.Start {
+		return b.After
+	}
+	return b.Start
+}
+
+// Scan - Return data in lexicographic key order between bounds, respond with ScanResponse
+func (s *Server) Scan(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered Scan method")
+	w.Header().Add("Content-Type", "application/json")
+
+	bounds, limit, err := parseScanBounds(r.URL.Query())
+	if err == nil && s.Reg.Placement != PlacementOrdered {
+		err = errors.New("Scans require a CAN created with ordered placement")
+	}
+	if err != nil {
+		log.Warn(err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	// Find the zone holding the start of the scan along the ordered axis
+	pt := Point{Coords: make([]float64, s.Reg.Dimension)}
+	pt.Coords[OrderedAxis] = orderKey(bounds.lowerKey())
+
+	log.WithFields(logrus.Fields{
+		"start":  bounds.Start,
+		"cursor": bounds.After,
+		"end":    bounds.End,
+		"prefix": bounds.Prefix,
+		"limit":  limit,
+	}).Debug("Parsed scan bounds")
+
+	inReg, neighbor := s.Reg.Locate(pt)
+	if inReg {
+		log.Debug("Processing Scan request")
+		sRes, err := s.walkScan(bounds, limit)
+		if err != nil {
+			log.Warn(err)
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(sRes)
+	} else { // Forward the scan request towards the zone holding its start
+		log.WithFields(logrus.Fields{
+			"IP":   neighbor.IP,
+			"Port": neighbor.Port,
+		}).Info("Forwarding Scan request to neighbor")
+
+		req, err := s.newForwardedRequest(r, neighbor, http.MethodGet, "/scan?"+r.URL.RawQuery, nil)
+		if err != nil {
+			forwardFailed(w, err)
+			return
+		}
+
+		resp, err := s.C.Do(req)
+		if err != nil {
+			forwardFailed(w, err)
+			return
+		}
+
+		// Errors from the zone holding the start reach the client with their own status
+		relayResponse(w, resp)
+	}
+
+	log.Info("Exiting Scan method")
+}
+
+// walkScan - Visit zones in order along the ordered axis until limit keys are known to be the smallest in bounds
+func (s *Server) walkScan(bounds ScanBounds, limit int) (*data.ScanResponse, error) {
+	lo := orderKey(bounds.lowerKey())
+	hi := 1.0
+	if bounds.End != "" {
+		hi = orderKey(bounds.End)
+	}
+
+	// Zones tile the space, so each one is identified by its range
+	space := s.Reg.Geometry().Space
+	queue := &zoneQueue{{space: space, local: true}}
+	seen := map[string]bool{rangeKey(&space): true}
+	items := []data.ScanItem{}
+	more := false // Keys in bounds may remain past the items kept
+
+	for queue.Len() > 0 {
+		// Stop once no unvisited zone can hold a key smaller than the last one we would return
+		if len(items) >= limit {
+			last := orderKey(items[limit-1].Key)
+			if (*queue)[0].space.P1.Coords[OrderedAxis] > last {
+				break
+			}
+		}
+
+		entry := heap.Pop(queue).(zoneEntry)
+
+		// Items found below the zone come before every key it holds, so it only has to fill the rest
+		want := limit
+		for _, item := range items {
+			if orderKey(item.Key) < entry.space.P1.Coords[OrderedAxis] {
+				want--
+			}
+		}
+
+		var zone *data.ZoneScanResponse
+		if entry.local {
+			zone = &data.ZoneScanResponse{
+				Items:     s.Reg.ScanData(bounds, want),
+				Neighbors: s.Reg.GetNeighborResponse(),
+			}
+		} else {
+			var err error
+			if zone, err = s.scanZone(entry.id, entry.host, bounds, want); err != nil {
+				return nil, err
+			}
+		}
+		if len(zone.Items) >= want {
+			more = true
+		}
+		items = append(items, zone.Items...)
+		sortScanItems(items)
+		if len(items) > limit {
+			items = items[:limit]
+			more = true
+		}
+
+		// Queue neighboring zones which overlap the scanned slab
+		for _, neighbor := range UnpackNeighbors(zone.Neighbors) {
+			rng := neighbor.Space
+			if seen[rangeKey(&rng)] || rng.P2.Coords[OrderedAxis] <= lo || rng.P1.Coords[OrderedAxis] > hi {
+				continue
+			}
+			seen[rangeKey(&rng)] = true
+			heap.Push(queue, zoneEntry{id: neighbor.ID, host: neighbor.Host, space: rng})
+		}
+	}
+
+	sRes := &data.ScanResponse{Items: items}
+	if len(items) == limit && (more || queue.Len() > 0) {
+		sRes.Cursor = items[limit-1].Key
+	}
+
+	return sRes, nil
+}
+
+// scanZone - Request the limit smallest keys within bounds held by the CAN server with ID id
+func (s *Server) scanZone(id string, hst Host, bounds ScanBounds, limit int) (*data.ZoneScanResponse, error) {
+	query := url.Values{}
+	query.Set("start", bounds.Start)
+	query.Set("cursor", bounds.After)
+	query.Set("end", bounds.End)
+	query.Set("prefix", bounds.Prefix)
+	query.Set("limit", strconv.Itoa(limit))
+
+	req, err := s.newSignedRequest(id, http.MethodGet, hst.URL("/scan/zone?"+query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.C.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		eRes := data.ErrorResponse{}
+		json.NewDecoder(resp.Body).Decode(&eRes)
+		return nil, errors.New("Zone scan at " + hst.String() + " failed with status " + resp.Status + ": " + eRes.Message)
+	}
+
+	zone := &data.ZoneScanResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(zone); err != nil {
+		return nil, err
+	}
+	if err := checkNeighbors(zone.Neighbors, s.Reg.Dimension); err != nil {
+		return nil, errors.New("Zone scan at " + hst.String() + " listed an invalid neighbor: " + err.Error())
+	}
+	return zone, nil
+}
+
+// ScanZone - Respond with the smallest keys in this region within bounds up to the limit, along with our
+// range and neighbors
+func (s *Server) ScanZone(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered ScanZone method")
+	w.Header().Add("Content-Type", "application/json")
+
+	bounds, limit, err := parseScanBounds(r.URL.Query())
+	if err != nil {
+		log.Warn(err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	zRes := &data.ZoneScanResponse{
+		Range:     *(s.Reg.Geometry().Space.GetRangeResponse()),
+		Items:     s.Reg.ScanData(bounds, limit),
+		Neighbors: s.Reg.GetNeighborResponse(),
+	}
+	json.NewEncoder(w).Encode(zRes)
+
+	log.Info("Exiting ScanZone method")
+}
+
+// sortScanItems - Sort scanned items by key
+func sortScanItems(items []data.ScanItem) {
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+}
+
+// rangeKey - Identify a zone by its bounds
+func rangeKey(rng *Range) string {
+	return fmt.Sprint(rng.P1.Coords, rng.P2.Coords)
+}
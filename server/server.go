@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
 	"io/ioutil"
 
+	"net"
 	"net/http"
-	"regexp"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"main/data"
 
@@ -19,20 +23,53 @@ import (
 
 var log = logrus.New()
 
+// SetLogLevel - Set the lowest level of messages logged by servers
+func SetLogLevel(level logrus.Level) {
+	log.SetLevel(level)
+}
+
 // Server - Object containing a region, HTTP client, and listening port
 type Server struct {
-	Reg  *Region
-	C    *http.Client
-	Port string
+	Reg       *Region
+	C         *http.Client
+	Port      string
+	ID        string // Stable identity of this server in neighbor tables
+	Advertise Host   // Address other servers reach us at, an empty IP is filled in by the receiver
+	Watchers  *WatchHub
+	Replicas  *ReplicaStore
+	Admission *Admission
+	Cache     *ReadCache
+	Secret    []byte // Signs membership traffic when set
+	Scheme    string // Scheme other servers reach us with, empty for plain HTTP
+	MutualTLS bool   // Membership requests require a client certificate
+
+	// Time a joiner has to commit before the split reserved for it is rolled back
+	JoinTimeout time.Duration
+
+	nonces   nonceCache
+	joinMu   sync.Mutex
+	pending  *pendingJoin
+	commits  []string      // Tokens of the latest joins committed, so a retried commit is acknowledged again
+	joinSlot chan struct{} // Held by the join being handed out, from reservation until commit or rollback
+	stateMu  sync.Mutex
+	state    string // Membership state, see SetState
 }
 
 // CreateServer - Create and return a server object
-func CreateServer(dim, red int, port string) *Server {
+func CreateServer(dim, red int, hasher Hasher, placement, port string) *Server {
 	// log.Level = logrus.DebugLevel
 	serv := &Server{
-		Reg:  CreateRegion(dim, red),
-		C:    &http.Client{},
-		Port: port,
+		Reg:       CreateRegion(dim, red, hasher, placement),
+		C:         &http.Client{},
+		Port:      port,
+		ID:        newNodeID(),
+		Advertise: Host{Port: port},
+		Watchers:  NewWatchHub(),
+		Replicas:  NewReplicaStore(),
+
+		JoinTimeout: DefaultJoinTimeout,
+		joinSlot:    make(chan struct{}, 1),
+		state:       StateStarting,
 	}
 	return serv
 }
@@ -44,55 +81,92 @@ func (s *Server) Join(w http.ResponseWriter, r *http.Request) {
 	// Add JSON headers and parse body to appropriate type
 	w.Header().Add("Content-Type", "application/json")
 	jr := data.ParseJoin(w, r)
-	pt := HashStringToPoint(jr.Key, s.Reg.Dimension)
+
+	// Check the joiner shares our parameters before anything is forwarded or reserved for it
+	if err := s.checkJoiner(jr.Manifest); err != nil {
+		log.Warn("Rejected join from " + jr.ID + ": " + err.Error())
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return
+	}
+	pt := s.Reg.HashKey(jr.Key)
+
+	// Remember where the joiner is before the request is forwarded on its behalf
+	joiner := fillAddress(jr.Address, r)
+	jr.Address = joiner.String()
 
 	log.WithFields(logrus.Fields{
 		"key":   jr.Key,
 		"point": pt,
 	}).Debug("Unmarshaled JoinRequest and hashed key")
 
-	// Determine if hashed point is in this region
+	// Joins at this server are handed out one at a time, and the slot is held while locating the point
+	// so no join commits and reshapes our zone in between
+	if !s.acquireJoinSlot() {
+		log.Warn("Timed out waiting for another join to finish")
+		w.Header().Set("Retry-After", retryAfter)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: "Another join is in progress, retry shortly"})
+		return
+	}
+
+	// Until a server holds a zone of its own, it must not split the whole space it starts with, joiners
+	// hold their slot until they have taken up their zone, so joins routed to them wait above
+	if state := s.State(); state != StateReady {
+		s.releaseJoinSlot()
+		log.Warn("Refused join from " + jr.ID + " while " + state)
+		w.Header().Set("Retry-After", retryAfter)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: stateMessages[state]})
+		return
+	}
+
+	// Determine if hashed point is in this region, joins for other zones are forwarded without the slot
 	inReg, neighbor := s.Reg.Locate(pt)
+	if !inReg {
+		s.releaseJoinSlot()
+	}
 	if inReg {
-		log.Info("Join request received, splitting region...")
-		newReg, delHosts := s.Reg.Split(r.Host)
+		log.Info("Join request received, reserving half of region...")
+		plan, err := s.Reg.PlanSplit(s.Self(r))
+		if err != nil {
+			s.releaseJoinSlot()
+			log.Warn(err)
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+			return
+		}
+		newReg := plan.NewReg
+		plan.Joiner = &Neighbor{
+			ID:    jr.ID,
+			Host:  joiner,
+			Space: *newReg.Space.Copy(),
+			Zone:  newReg.Zone,
+			Epoch: newReg.Epoch,
+		}
+		token := s.reserveJoin(plan, joiner)
 
-		// Encode the response to JSON body and send it
+		// Encode the response to JSON body and send it, the split only happens once the joiner commits
 		jRes := &data.JoinResponse{
+			Epoch:      newReg.Epoch,
 			Dimension:  newReg.Dimension,
 			Redundancy: newReg.Redundancy,
+			Hasher:     newReg.Hasher.Name(),
+			Placement:  newReg.Placement,
+			Torus:      newReg.Torus,
+			Split:      newReg.SplitStrategy,
+			History:    newReg.GetHistoryResponse(),
 			Range:      *(newReg.Space.GetRangeResponse()),
-			Data:       newReg.Data,
+			Zone:       string(newReg.Zone),
+			Data:       newReg.GetDataResponse(),
 			Neighbors:  newReg.GetNeighborResponse(),
+			Owner:      s.ID,
+			Token:      token,
 		}
-		json.NewEncoder(w).Encode(jRes)
-
-		// Update our neighbors with our new region
-		neighborReq := &data.NeighborRequest{
-			Port:  s.Port,
-			Range: *(s.Reg.Space.GetRangeResponse()),
-		}
-
-		body, _ := json.Marshal(neighborReq)
-
-		// Request existing neighbors to update my range in their map
-		for hst := range s.Reg.Neighbors {
-			req, _ := http.NewRequest(http.MethodPatch, fmt.Sprintf("http://%s:%s/neighbors", hst.IP, hst.Port), bytes.NewBuffer(body))
-			_, err := s.C.Do(req)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
-
-		// Request neighbors that are no longer adjacent to delete me
-		for _, hst := range delHosts {
-			req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s:%s/neighbors?port=%s", hst.IP, hst.Port, s.Port), bytes.NewBuffer(body))
-			_, err := s.C.Do(req)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
-
+		resBody, _ := json.Marshal(jRes)
+		s.signResponse(w, resBody)
+		w.Write(resBody)
 	} else {
 		// Forward join request to best neighbor
 		log.WithFields(logrus.Fields{
@@ -101,63 +175,171 @@ func (s *Server) Join(w http.ResponseWriter, r *http.Request) {
 		}).Info("Forwarding Join request to neighbor")
 
 		body, _ := json.Marshal(jr)
-		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s:%s/join", neighbor.IP, neighbor.Port), bytes.NewBuffer(body))
+		req, err := s.newSignedRequest(neighbor.ID, http.MethodPost, neighbor.URL("/join"), body)
 
 		resp, err := s.C.Do(req)
 		if err != nil {
-			log.Fatal(err)
+			forwardFailed(w, err)
+			return
 		}
 
-		frwdResponse, _ := ioutil.ReadAll(resp.Body)
-		w.Write(frwdResponse)
+		// The owner's signature is passed back to the joiner along with the response
+		relayResponse(w, resp)
 	}
 	log.Info("Exiting Join method")
 }
 
-// SendJoin - Send a JoinRequest to entry point in CAN
-func (s *Server) SendJoin(host, port, key string) {
+// SendJoin - Send a JoinRequest to entry point in CAN, adopting the region it responds with
+func (s *Server) SendJoin(host, key string) error {
 	// Send a join request to an existing CAN server
 	log.Print("Attempting to join network at " + host)
+	seed := ParseHost(host, s.Scheme)
+
+	// Not ready until we hold a zone and our neighbors know of us
+	s.SetState(StateJoining)
+	joined := false
+	defer func() {
+		if !joined {
+			s.SetState(StateStarting)
+		}
+	}()
+
+	// Refuse to join a CAN whose parameters differ from ours, rather than silently adopting them
+	manifest := s.Manifest()
+	can, err := s.fetchManifest(seed)
+	if err != nil {
+		return errors.New("Could not read cluster manifest: " + err.Error())
+	}
+	if err := CheckManifest(*can, manifest); err != nil {
+		return err
+	}
+
 	jr := &data.JoinRequest{
-		Key: key,
+		Key:      key,
+		ID:       s.ID,
+		Address:  s.Advertise.String(),
+		Manifest: &manifest,
 	}
 	body, _ := json.Marshal(jr)
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/join", host), bytes.NewBuffer(body))
+	req, err := s.newSignedRequest(can.Node, http.MethodPost, seed.URL("/join"), body)
+	if err != nil {
+		return err
+	}
 
 	resp, err := s.C.Do(req)
-	log.Print("Received join response containing new region")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	resBody, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		eRes := data.ErrorResponse{}
+		json.Unmarshal(resBody, &eRes)
+		return errors.New("Join rejected with status " + resp.Status + ": " + eRes.Message)
+	}
+	if err := s.checkResponse(resp, resBody); err != nil {
+		return errors.New("Join response failed verification: " + err.Error())
+	}
+	log.Print("Received join response containing new region")
 
 	// Handle response
 	jRes := data.JoinResponse{}
-	json.NewDecoder(resp.Body).Decode(&jRes)
+	if err := json.Unmarshal(resBody, &jRes); err != nil {
+		return err
+	}
 
-	s.Reg = &Region{
-		Dimension:  jRes.Dimension,
-		Redundancy: jRes.Redundancy,
-		Space:      *UnpackRange(jRes.Range),
-		Data:       jRes.Data,
-		Neighbors:  UnpackNeighbors(jRes.Neighbors),
+	// Adopt the hasher the CAN was created with
+	hasher, err := GetHasher(jRes.Hasher)
+	if err != nil {
+		return err
+	}
+	if err := CheckPlacement(jRes.Placement); err != nil {
+		return err
+	}
+	if err := CheckSplit(jRes.Split); err != nil {
+		return err
+	}
+	if !ZoneID(jRes.Zone).Valid() {
+		return errors.New("Join response carries an invalid zone ID " + strconv.Quote(jRes.Zone))
+	}
+	if jRes.Dimension != s.Reg.Dimension {
+		return errors.New("Join response is for a CAN of " + strconv.Itoa(jRes.Dimension) + " dimensions")
+	}
+	if err := CheckRange(jRes.Range, jRes.Dimension); err != nil {
+		return errors.New("Join response carries an invalid range: " + err.Error())
+	}
+	if err := checkNeighbors(jRes.Neighbors, jRes.Dimension); err != nil {
+		return errors.New("Join response carries an invalid neighbor: " + err.Error())
+	}
+	for _, st := range jRes.History {
+		if st.Axis < 0 || st.Axis >= jRes.Dimension {
+			return errors.New("Join response carries a split along axis " + strconv.Itoa(st.Axis))
+		}
 	}
 
+	reg := &Region{
+		Dimension:     jRes.Dimension,
+		Redundancy:    jRes.Redundancy,
+		Space:         *UnpackRange(jRes.Range),
+		Zone:          ZoneID(jRes.Zone),
+		Data:          UnpackData(jRes.Data),
+		Tombstones:    make(map[string]uint64),
+		Neighbors:     UnpackNeighbors(jRes.Neighbors),
+		Hasher:        hasher,
+		Placement:     jRes.Placement,
+		Torus:         jRes.Torus,
+		Epoch:         jRes.Epoch,
+		SplitStrategy: jRes.Split,
+		History:       UnpackHistory(jRes.History),
+	}
+
+	// The owner keeps the zone until we confirm we hold it, so a failed join loses nothing
+	owner, prs := reg.Neighbors[jRes.Owner]
+	if !prs {
+		return errors.New("Join response does not list the owner " + jRes.Owner + " as a neighbor")
+	}
+
+	// Joins routed to us once the owner commits wait until we have taken up our zone and announced it
+	s.joinSlot <- struct{}{}
+	defer s.releaseJoinSlot()
+
+	// Handlers are already serving, so the zone is swapped in under the region's locks
+	prev := s.Reg.takeZone(reg)
+	if err := s.sendCommit(owner, jRes.Token); err != nil {
+		s.Reg.takeZone(prev)
+		return errors.New("Join was not committed: " + err.Error())
+	}
+	log.Print("Committed join with " + owner.ID)
+
+	// The owner already forwards requests for our zone to us, the rest of our neighbors learn of us next
+	joined = true
+	s.SetState(StateReady)
+
 	// Update our neighbors with our new region
+	geo := s.Reg.Geometry()
 	neighborReq := &data.NeighborRequest{
-		Port:  s.Port,
-		Range: *(s.Reg.Space.GetRangeResponse()),
+		ID:      s.ID,
+		Address: s.Advertise.String(),
+		Range:   *(geo.Space.GetRangeResponse()),
+		Zone:    string(geo.Zone),
+		Epoch:   geo.Epoch,
 	}
 
 	body, _ = json.Marshal(neighborReq)
 
 	// Tell our new neighbors to add us
-	for hst := range s.Reg.Neighbors {
-		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s:%s/neighbors", hst.IP, hst.Port), bytes.NewBuffer(body))
-		_, err := s.C.Do(req)
+	for _, neighbor := range s.Reg.NeighborList() {
+		// We already own our region, so a neighbor we cannot reach must not undo the join
+		req, _ := s.newSignedRequest(neighbor.ID, http.MethodPut, neighbor.URL("/neighbors"), body)
+		resp, err := s.C.Do(req)
 		if err != nil {
-			log.Fatal(err)
+			log.Warn("Could not add ourselves to neighbor " + neighbor.ID + ": " + err.Error())
+			continue
 		}
+		resp.Body.Close()
 	}
+	return nil
 }
 
 // Debug - Send a DebugResponse with information about this server in the CAN
@@ -165,12 +347,28 @@ func (s *Server) Debug(w http.ResponseWriter, r *http.Request) {
 	log.Info("Entered Debug method")
 	w.Header().Add("Content-Type", "application/json")
 
+	geo := s.Reg.Geometry()
 	dRes := &data.DebugResponse{
+		ID:         s.ID,
+		Address:    s.Self(r).String(),
 		Dimension:  s.Reg.Dimension,
 		Redundancy: s.Reg.Redundancy,
-		Range:      *(s.Reg.Space.GetRangeResponse()),
+		Hasher:     s.Reg.Hasher.Name(),
+		Placement:  s.Reg.Placement,
+		Torus:      s.Reg.Torus,
+		Split:      s.Reg.SplitStrategy,
+		History:    s.Reg.GetHistoryResponse(),
+		Range:      *(geo.Space.GetRangeResponse()),
+		Zone:       string(geo.Zone),
 		Neighbors:  s.Reg.GetNeighborResponse(),
-		Data:       s.Reg.Data,
+		Data:       s.Reg.GetDataResponse(),
+		Replicas:   s.Replicas.Counts(),
+	}
+	if s.Cache != nil {
+		dRes.Cache = s.Cache.Stats()
+	}
+	if s.Admission != nil {
+		dRes.Admission = s.Admission.Stats()
 	}
 
 	log.Info("Sending Debug response")
@@ -184,7 +382,7 @@ func (s *Server) RouteTrace(w http.ResponseWriter, r *http.Request) {
 	log.Info("Entered RouteTrace method")
 	w.Header().Add("Content-Type", "application/json")
 	dr := data.ParseData(w, r)
-	pt := HashStringToPoint(dr.Key, s.Reg.Dimension)
+	pt := s.Reg.HashKey(dr.Key)
 
 	log.WithFields(logrus.Fields{
 		"key":   dr.Key,
@@ -207,11 +405,16 @@ func (s *Server) RouteTrace(w http.ResponseWriter, r *http.Request) {
 		}).Info("Forwarding RouteTrace request to neighbor")
 
 		body, _ := json.Marshal(dr)
-		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s:%s/trace", neighbor.IP, neighbor.Port), bytes.NewBuffer(body))
+		req, err := s.newForwardedRequest(r, neighbor, http.MethodPost, "/trace", body)
+		if err != nil {
+			forwardFailed(w, err)
+			return
+		}
 
 		resp, err := s.C.Do(req)
 		if err != nil {
-			log.Fatal(err)
+			forwardFailed(w, err)
+			return
 		}
 
 		tr := data.ParseTrace(w, resp)
@@ -231,7 +434,7 @@ func (s *Server) PutData(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Add("Content-Type", "application/json")
 	dr := data.ParseData(w, r)
-	pt := HashStringToPoint(dr.Key, s.Reg.Dimension)
+	pt := s.Reg.HashKey(dr.Key)
 
 	log.WithFields(logrus.Fields{
 		"key":   dr.Key,
@@ -243,7 +446,24 @@ func (s *Server) PutData(w http.ResponseWriter, r *http.Request) {
 	inReg, neighbor := s.Reg.Locate(pt)
 	if inReg {
 		log.Debug("Processing PutData request")
-		added, err := s.Reg.AddData(pt, dr.Key, dr.Data) // Add to this region
+		rec := NewRecord([]byte(dr.Data), dr.ContentType)
+		if rec.ContentType == "" {
+			rec.ContentType = DefaultTextType
+		}
+		added := false
+		expires, _, err := ParseExpiry(dr.TTL, dr.ExpiresAt)
+		cond, condErr := ParsePrecondition(r, dr.Version)
+		if err == nil && condErr != nil {
+			err = condErr
+		}
+		if err == nil {
+			rec.Expires = expires
+			if dr.Upsert {
+				added, err = s.Reg.UpsertData(pt, dr.Key, &rec, cond) // Add or replace in this region
+			} else {
+				added, err = s.Reg.AddData(pt, dr.Key, &rec, cond) // Add to this region
+			}
+		}
 
 		// Send success/failure message
 		if err != nil {
@@ -251,15 +471,14 @@ func (s *Server) PutData(w http.ResponseWriter, r *http.Request) {
 			dRes := &data.ErrorResponse{
 				Message: err.Error(),
 			}
+			setRetryAfter(w, err)
+			w.WriteHeader(statusForError(err))
 			json.NewEncoder(w).Encode(dRes)
 		} else if added {
-			dRes := &data.DataResponse{
-				Key:     dr.Key,
-				Data:    dr.Data,
-				Coords:  pt.Coords,
-				Message: "Data successfully added",
-			}
-			json.NewEncoder(w).Encode(dRes)
+			s.Watchers.Publish(EventPut, dr.Key, &rec)
+			go s.replicate(dr.Key)
+			w.Header().Set("ETag", ETag(rec.Version))
+			json.NewEncoder(w).Encode(rec.GetDataResponse(dr.Key, pt, "Data successfully added"))
 		}
 	} else { // Forward the put request to the appropriate neighbor
 		log.WithFields(logrus.Fields{
@@ -268,15 +487,9 @@ func (s *Server) PutData(w http.ResponseWriter, r *http.Request) {
 		}).Info("Forwarding PutData request to neighbor")
 
 		body, _ := json.Marshal(dr)
-		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("http://%s:%s/data", neighbor.IP, neighbor.Port), bytes.NewBuffer(body))
-
-		resp, err := s.C.Do(req)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		frwdResponse, _ := ioutil.ReadAll(resp.Body)
-		w.Write(frwdResponse)
+		s.invalidate(dr.Key)
+		s.forward(w, r, neighbor, pt, http.MethodPut, "/data", body, "")
+		s.invalidate(dr.Key)
 	}
 
 	log.Info("Exiting PutData method")
@@ -288,7 +501,7 @@ func (s *Server) PatchData(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Add("Content-Type", "application/json")
 	dr := data.ParseData(w, r)
-	pt := HashStringToPoint(dr.Key, s.Reg.Dimension)
+	pt := s.Reg.HashKey(dr.Key)
 
 	log.WithFields(logrus.Fields{
 		"key":   dr.Key,
@@ -300,7 +513,20 @@ func (s *Server) PatchData(w http.ResponseWriter, r *http.Request) {
 	inReg, neighbor := s.Reg.Locate(pt)
 	if inReg {
 		log.Debug("Processing PatchData request")
-		added, err := s.Reg.ModifyData(pt, dr.Key, dr.Data) // Add to this region
+		rec := NewRecord([]byte(dr.Data), dr.ContentType)
+		if rec.ContentType == "" {
+			rec.ContentType = DefaultTextType
+		}
+		added := false
+		expires, keep, err := ParseExpiry(dr.TTL, dr.ExpiresAt)
+		cond, condErr := ParsePrecondition(r, dr.Version)
+		if err == nil && condErr != nil {
+			err = condErr
+		}
+		if err == nil {
+			rec.Expires = expires
+			added, err = s.Reg.ModifyData(pt, dr.Key, &rec, keep, cond) // Modify in this region
+		}
 
 		// Send success/failure message
 		if err != nil {
@@ -308,15 +534,14 @@ func (s *Server) PatchData(w http.ResponseWriter, r *http.Request) {
 			dRes := &data.ErrorResponse{
 				Message: err.Error(),
 			}
+			setRetryAfter(w, err)
+			w.WriteHeader(statusForError(err))
 			json.NewEncoder(w).Encode(dRes)
 		} else if added {
-			dRes := &data.DataResponse{
-				Key:     dr.Key,
-				Data:    dr.Data,
-				Coords:  pt.Coords,
-				Message: "Data successfully modified",
-			}
-			json.NewEncoder(w).Encode(dRes)
+			s.Watchers.Publish(EventPatch, dr.Key, &rec)
+			go s.replicate(dr.Key)
+			w.Header().Set("ETag", ETag(rec.Version))
+			json.NewEncoder(w).Encode(rec.GetDataResponse(dr.Key, pt, "Data successfully modified"))
 		}
 	} else { // Forward the put request to the appropriate neighbor
 		log.WithFields(logrus.Fields{
@@ -325,18 +550,88 @@ func (s *Server) PatchData(w http.ResponseWriter, r *http.Request) {
 		}).Info("Forwarding PatchData request to neighbor")
 
 		body, _ := json.Marshal(dr)
-		req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("http://%s:%s/data", neighbor.IP, neighbor.Port), bytes.NewBuffer(body))
+		s.invalidate(dr.Key)
+		s.forward(w, r, neighbor, pt, http.MethodPatch, "/data", body, "")
+		s.invalidate(dr.Key)
+	}
 
-		resp, err := s.C.Do(req)
+	log.Info("Exiting PatchData method")
+}
+
+// PutRawData - Add Data to CAN from a raw request body, keeping its content type, respond with DataResponse
+func (s *Server) PutRawData(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered PutRawData method")
+
+	key := chi.URLParam(r, "key")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = DefaultBinaryType
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	pt := s.Reg.HashKey(key)
+
+	log.WithFields(logrus.Fields{
+		"key":         key,
+		"size":        len(body),
+		"contentType": contentType,
+		"point":       pt,
+	}).Debug("Read raw data request and hashed key")
+
+	// Determine if the key is in region, find neighbor if not
+	inReg, neighbor := s.Reg.Locate(pt)
+	if inReg {
+		log.Debug("Processing PutRawData request")
+		rec := NewRecord(body, contentType)
+		added := false
+		expires, err := parseRawExpiry(r)
+		cond, condErr := ParsePrecondition(r, nil)
+		if err == nil && condErr != nil {
+			err = condErr
+		}
+		if err == nil {
+			rec.Expires = expires
+			if r.URL.Query().Get("upsert") == "true" {
+				added, err = s.Reg.UpsertData(pt, key, &rec, cond) // Add or replace in this region
+			} else {
+				added, err = s.Reg.AddData(pt, key, &rec, cond) // Add to this region
+			}
+		}
+
+		// Send success/failure message
 		if err != nil {
-			log.Fatal(err)
+			log.Warn(err)
+			dRes := &data.ErrorResponse{
+				Message: err.Error(),
+			}
+			setRetryAfter(w, err)
+			w.WriteHeader(statusForError(err))
+			json.NewEncoder(w).Encode(dRes)
+		} else if added {
+			s.Watchers.Publish(EventPut, key, &rec)
+			go s.replicate(key)
+			w.Header().Set("ETag", ETag(rec.Version))
+			dRes := rec.GetDataResponse(key, pt, "Data successfully added")
+			dRes.Data = ""
+			json.NewEncoder(w).Encode(dRes)
 		}
+	} else { // Forward the raw body to the appropriate neighbor
+		log.WithFields(logrus.Fields{
+			"IP":   neighbor.IP,
+			"Port": neighbor.Port,
+		}).Info("Forwarding PutRawData request to neighbor")
 
-		frwdResponse, _ := ioutil.ReadAll(resp.Body)
-		w.Write(frwdResponse)
+		s.invalidate(key)
+		s.forward(w, r, neighbor, pt, http.MethodPut, "/data/"+url.PathEscape(key)+"?"+r.URL.RawQuery, body, contentType)
+		s.invalidate(key)
 	}
 
-	log.Info("Exiting PatchData method")
+	log.Info("Exiting PutRawData method")
 }
 
 // GetData - Retrieve Data in a CAN, respond with DataResponse
@@ -345,7 +640,7 @@ func (s *Server) GetData(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Add("Content-Type", "application/json")
 	key := chi.URLParam(r, "key")
-	pt := HashStringToPoint(key, s.Reg.Dimension)
+	pt := s.Reg.HashKey(key)
 
 	log.WithFields(logrus.Fields{
 		"key":   key,
@@ -356,7 +651,7 @@ func (s *Server) GetData(w http.ResponseWriter, r *http.Request) {
 	inReg, neighbor := s.Reg.Locate(pt)
 	if inReg {
 		log.Debug("Processing GetData request")
-		got, datum, err := s.Reg.GetData(pt, key)
+		got, rec, err := s.Reg.GetData(pt, key)
 
 		// Send success/failure message
 		if err != nil {
@@ -364,15 +659,24 @@ func (s *Server) GetData(w http.ResponseWriter, r *http.Request) {
 			dRes := &data.ErrorResponse{
 				Message: err.Error(),
 			}
+			setRetryAfter(w, err)
+			w.WriteHeader(statusForError(err))
 			json.NewEncoder(w).Encode(dRes)
 		} else if got {
-			dRes := &data.DataResponse{
-				Key:     key,
-				Data:    datum,
-				Coords:  pt.Coords,
-				Message: "Data successfully retrieved",
+			// Caches along the route keep either form of the response no longer than the record lives
+			w.Header().Set("ETag", ETag(rec.Version))
+			if !rec.Expires.IsZero() {
+				w.Header().Set("Expires", rec.Expires.Format(http.TimeFormat))
+			}
+			if wantsJSON(r) {
+				json.NewEncoder(w).Encode(rec.GetDataResponse(key, pt, "Data successfully retrieved"))
+			} else { // Send the raw value with its own content type
+				w.Header().Set("Content-Type", rec.ContentType)
+				w.Header().Set("Content-Length", strconv.Itoa(rec.Size()))
+				w.Header().Set("Last-Modified", rec.Modified.Format(http.TimeFormat))
+				w.Header().Set(checksumHeader, rec.Checksum)
+				w.Write(rec.Value)
 			}
-			json.NewEncoder(w).Encode(dRes)
 		}
 
 	} else { // Forward the get request to the appropriate neighbor
@@ -381,15 +685,37 @@ func (s *Server) GetData(w http.ResponseWriter, r *http.Request) {
 			"Port": neighbor.Port,
 		}).Info("Forwarding GetData request to neighbor")
 
-		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s:%s/data/%s", neighbor.IP, neighbor.Port, key), nil)
+		// Hot keys are answered from what earlier reads forwarded through here returned
+		asJSON := wantsJSON(r)
+		cacheable := s.Cache != nil && r.Header.Get("If-Match") == "" && r.Header.Get("If-None-Match") == ""
+		var mark uint64
+		if cacheable {
+			if hit, ok := s.Cache.Get(key, asJSON, time.Now()); ok {
+				log.Debug("Answered GetData request from read cache")
+				hit.write(w)
+				log.Info("Exiting GetData method")
+				return
+			}
+			mark = s.Cache.Mark()
+		}
 
-		resp, err := s.C.Do(req)
-		if err != nil {
-			log.Fatal(err)
+		resp := s.sendForward(w, r, neighbor, pt, http.MethodGet, "/data/"+url.PathEscape(key), nil, "")
+		if resp == nil {
+			return
 		}
 
-		frwdResponse, _ := ioutil.ReadAll(resp.Body)
-		w.Write(frwdResponse)
+		// Responses from caches further along are not cached again, so no entry outlives the TTL
+		if cacheable && resp.StatusCode == http.StatusOK && resp.Header.Get(cacheHeader) == "" {
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				forwardFailed(w, err)
+				return
+			}
+			s.Cache.Put(key, asJSON, resp.Header, body, time.Now(), mark)
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		relayResponse(w, resp)
 	}
 
 	log.Info("Exiting GetData method")
@@ -401,7 +727,7 @@ func (s *Server) DeleteData(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Add("Content-Type", "application/json")
 	key := chi.URLParam(r, "key")
-	pt := HashStringToPoint(key, s.Reg.Dimension)
+	pt := s.Reg.HashKey(key)
 
 	log.WithFields(logrus.Fields{
 		"key":   key,
@@ -412,7 +738,11 @@ func (s *Server) DeleteData(w http.ResponseWriter, r *http.Request) {
 	inReg, neighbor := s.Reg.Locate(pt)
 	if inReg {
 		log.Debug("Processing DeleteData request")
-		deleted, datum, err := s.Reg.DeleteData(pt, key)
+		deleted, rec := false, Record{}
+		cond, err := ParsePrecondition(r, nil)
+		if err == nil {
+			deleted, rec, err = s.Reg.DeleteData(pt, key, cond)
+		}
 
 		// Send success/failure message
 		if err != nil {
@@ -420,15 +750,13 @@ func (s *Server) DeleteData(w http.ResponseWriter, r *http.Request) {
 			dRes := &data.ErrorResponse{
 				Message: err.Error(),
 			}
+			setRetryAfter(w, err)
+			w.WriteHeader(statusForError(err))
 			json.NewEncoder(w).Encode(dRes)
 		} else if deleted {
-			dRes := &data.DataResponse{
-				Key:     key,
-				Data:    datum,
-				Coords:  pt.Coords,
-				Message: "Data successfully deleted",
-			}
-			json.NewEncoder(w).Encode(dRes)
+			s.Watchers.Publish(EventDelete, key, nil)
+			go s.replicate(key)
+			json.NewEncoder(w).Encode(rec.GetDataResponse(key, pt, "Data successfully deleted"))
 		}
 
 	} else { // Forward the get request to the appropriate neighbor
@@ -437,33 +765,67 @@ func (s *Server) DeleteData(w http.ResponseWriter, r *http.Request) {
 			"Port": neighbor.Port,
 		}).Info("Forwarding DeleteData request to neighbor")
 
-		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://%s:%s/data/%s", neighbor.IP, neighbor.Port, key), nil)
-
-		resp, err := s.C.Do(req)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// log.Print(resp)
-		frwdResponse, _ := ioutil.ReadAll(resp.Body)
-		w.Write(frwdResponse)
+		s.invalidate(key)
+		s.forward(w, r, neighbor, pt, http.MethodDelete, "/data/"+url.PathEscape(key), nil, "")
+		s.invalidate(key)
 	}
 
 	log.Info("Exiting DeleteData method")
 }
 
+// parseNeighbor - Decode the zone a neighbor sent of itself, responding with 400 if it is malformed
+func (s *Server) parseNeighbor(w http.ResponseWriter, r *http.Request) (data.NeighborRequest, bool) {
+	nr := data.NeighborRequest{}
+	err := json.NewDecoder(r.Body).Decode(&nr)
+	if err == nil {
+		err = CheckRange(nr.Range, s.Reg.Dimension)
+	}
+	if err != nil {
+		log.Warn(err)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return nr, false
+	}
+	return nr, true
+}
+
 // AddNeighbor - Add sender as a neighbor
 func (s *Server) AddNeighbor(w http.ResponseWriter, r *http.Request) {
 	log.Info("Entered AddNeighbor method")
 
-	nr := data.ParseNeighbor(w, r)
-	nHost, _ := getHostFromRemoteAddr(r.RemoteAddr)
-	err := s.Reg.AddNeighbor(nHost, nr.Port, *UnpackRange(nr.Range))
+	nr, ok := s.parseNeighbor(w, r)
+	if !ok {
+		return
+	}
+	neighbor := Neighbor{
+		ID:    nr.ID,
+		Host:  fillAddress(nr.Address, r),
+		Space: *UnpackRange(nr.Range),
+		Zone:  ZoneID(nr.Zone),
+		Epoch: nr.Epoch,
+	}
+
+	// A zone cut at the same time as ours may introduce itself after we no longer border it
+	if !s.Reg.Borders(neighbor.Zone, &neighbor.Space) {
+		log.Info("Node " + neighbor.ID + " does not border our zone, not adding it")
+		if r.URL.Query().Get(introducedParam) == "" {
+			s.passOn(neighbor)
+		}
+		log.Info("Exiting AddNeighbor method")
+		return
+	}
+
+	err := s.Reg.AddNeighbor(neighbor)
+	if errors.Is(err, ErrNeighborExists) {
+		// The server which split its zone for a joiner already lists it
+		err = s.Reg.UpdateNeighbor(neighbor)
+	}
 
 	log.WithFields(logrus.Fields{
-		"IP":    nHost,
-		"Port":  nr.Port,
-		"Range": *UnpackRange(nr.Range),
+		"ID":      neighbor.ID,
+		"Address": neighbor.Host.String(),
+		"Range":   neighbor.Space,
 	}).Info("Added neighbor to region")
 
 	if err != nil {
@@ -477,33 +839,34 @@ func (s *Server) AddNeighbor(w http.ResponseWriter, r *http.Request) {
 	log.Info("Exiting AddNeighbor method")
 }
 
-// PatchNeighbor - Update sender as a neighbor
+// PatchNeighbor - Update the range and address of the sender as a neighbor
 func (s *Server) PatchNeighbor(w http.ResponseWriter, r *http.Request) {
 	log.Info("Entered PatchNeighbor method")
 
-	nr := data.ParseNeighbor(w, r)
-	nHost, _ := getHostFromRemoteAddr(r.RemoteAddr)
-
-	host := Host{
-		IP:   nHost,
-		Port: nr.Port,
+	nr, ok := s.parseNeighbor(w, r)
+	if !ok {
+		return
 	}
 
-	_, prs := s.Reg.Neighbors[host]
-	if !prs {
-		err := errors.New("Host does not exist in neighbor map")
+	// The address is refreshed too, so a neighbor may move without rejoining
+	neighbor := Neighbor{
+		ID:    nr.ID,
+		Host:  fillAddress(nr.Address, r),
+		Space: *UnpackRange(nr.Range),
+		Zone:  ZoneID(nr.Zone),
+		Epoch: nr.Epoch,
+	}
+	if err := s.Reg.UpdateNeighbor(neighbor); err != nil {
 		log.Warn(err)
 		dRes := &data.ErrorResponse{
 			Message: err.Error(),
 		}
 		json.NewEncoder(w).Encode(dRes)
 	} else {
-		s.Reg.Neighbors[host] = *UnpackRange(nr.Range)
-
 		log.WithFields(logrus.Fields{
-			"IP":    nHost,
-			"Port":  nr.Port,
-			"Range": *UnpackRange(nr.Range),
+			"ID":      neighbor.ID,
+			"Address": neighbor.Host.String(),
+			"Range":   neighbor.Space,
 		}).Info("Updated range for neighbor")
 	}
 
@@ -514,27 +877,18 @@ func (s *Server) PatchNeighbor(w http.ResponseWriter, r *http.Request) {
 func (s *Server) DeleteNeighbor(w http.ResponseWriter, r *http.Request) {
 	log.Info("Entered DeleteNeighbor method")
 
-	nPort := r.URL.Query().Get("port")
-	nHost, _ := getHostFromRemoteAddr(r.RemoteAddr)
-	host := Host{
-		IP:   nHost,
-		Port: nPort,
-	}
+	id := r.URL.Query().Get("id")
 
-	_, prs := s.Reg.Neighbors[host]
-	if !prs {
-		err := errors.New("Host does not exist in neighbor map")
+	if !s.Reg.RemoveNeighbor(id) {
+		err := errors.New("Node " + id + " does not exist in neighbor map")
 		log.Warn(err)
 		dRes := &data.ErrorResponse{
 			Message: err.Error(),
 		}
 		json.NewEncoder(w).Encode(dRes)
 	} else {
-		delete(s.Reg.Neighbors, host)
-
 		log.WithFields(logrus.Fields{
-			"IP":   nHost,
-			"Port": nPort,
+			"ID": id,
 		}).Info("Deleted neighbor")
 	}
 
@@ -554,18 +908,145 @@ func (s *Server) DataOptions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Allow", "OPTIONS, GET, DELETE, PUT, PATCH")
 }
 
+// ScanOptions - Retrieve available HTTP Options at scan endpoint
+func (s *Server) ScanOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Allow", "OPTIONS, GET")
+}
+
+// WatchOptions - Retrieve available HTTP Options at watch endpoint
+func (s *Server) WatchOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Allow", "OPTIONS, GET")
+}
+
 // DebugOptions - Retrieve available HTTP Options at debug endpoint
 func (s *Server) DebugOptions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Allow", "OPTIONS, GET")
 }
 
-func getHostFromRemoteAddr(remoteAddr string) (string, string) {
-	r := regexp.MustCompile(`^(\[::1\]):([0-9]*)$`) // Handle [::1] = localhost in IPv6
-	if r.MatchString(remoteAddr) {
-		splt := r.FindStringSubmatch(remoteAddr) // "[::1]:12345" -> ["[::1]:12345", "[::1]", "12345"]
-		return "localhost", splt[2]              // splt[2] is the port
+// parseRawExpiry - Read the expiry of a raw write from its ttl or expiresAt query parameters
+func parseRawExpiry(r *http.Request) (time.Time, error) {
+	var ttl int64
+	var at *time.Time
+
+	query := r.URL.Query()
+	if t := query.Get("ttl"); t != "" {
+		var err error
+		if ttl, err = strconv.ParseInt(t, 10, 64); err != nil {
+			return time.Time{}, errors.New("ttl must be a whole number of seconds")
+		}
+	}
+	if e := query.Get("expiresAt"); e != "" {
+		parsed, err := time.Parse(time.RFC3339, e)
+		if err != nil {
+			return time.Time{}, errors.New("expiresAt must be an RFC 3339 time")
+		}
+		at = &parsed
 	}
 
-	joinerInfo := strings.Split(remoteAddr, ":")
-	return joinerInfo[0], joinerInfo[1]
+	expires, _, err := ParseExpiry(ttl, at)
+	return expires, err
+}
+
+// StartReaper - Periodically evict expired data from the region until stop is closed
+func (s *Server) StartReaper(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				reaped := s.Reg.ReapExpired(now)
+				for _, key := range reaped {
+					s.Watchers.Publish(EventExpire, key, nil)
+				}
+				if len(reaped) > 0 {
+					log.WithFields(logrus.Fields{
+						"count": len(reaped),
+					}).Info("Reaped expired data")
+				}
+
+				// Deletions only need remembering until every replica has heard of them
+				s.Reg.PurgeTombstones(now.Add(-TombstoneTTL))
+				s.Replicas.Reap(now)
+			}
+		}
+	}()
+}
+
+// checksumHeader - Header carrying the SHA-256 checksum of a raw value
+const checksumHeader = "X-Content-Sha256"
+
+// wantsJSON - Determine if a client asked for the JSON form of data rather than the raw value
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Accept"), "application/json")
+}
+
+// forwardedHeaders - Client headers which must reach the server owning a key
+var forwardedHeaders = []string{"Accept", "If-Match", "If-None-Match"}
+
+// copyHeaders - Copy the client headers which affect a data request onto a forwarded request
+func copyHeaders(req *http.Request, r *http.Request) {
+	for _, name := range forwardedHeaders {
+		if val := r.Header.Get(name); val != "" {
+			req.Header.Set(name, val)
+		}
+	}
+}
+
+// statusForError - Choose the HTTP status reporting an error from a region
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrKeyExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrNotInRange):
+		return http.StatusInternalServerError
+	case errors.Is(err, ErrSplitPending):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// retryAfter - Seconds a client should wait before retrying a request which could not be served yet
+const retryAfter = "1"
+
+// setRetryAfter - Tell the client when to retry if an error from a region is only temporary
+func setRetryAfter(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrSplitPending) {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+}
+
+// forwardFailed - Report that a request could not be forwarded to the neighbor responsible for it
+func forwardFailed(w http.ResponseWriter, err error) {
+	log.Warn("Could not forward request: " + err.Error())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadGateway)
+	json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+}
+
+// relayResponse - Copy a forwarded response's headers, status and body back to the client
+func relayResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	for name, vals := range resp.Header {
+		w.Header()[name] = vals
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// getHostFromRemoteAddr - Split a request's remote address into its IP and port
+func getHostFromRemoteAddr(remoteAddr string) (string, string) {
+	ip, port, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		// Proxies may leave a bare IP behind, as middleware.RealIP does
+		return strings.TrimSuffix(strings.TrimPrefix(remoteAddr, "["), "]"), ""
+	}
+	return ip, port
 }
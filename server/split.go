@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"main/data"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Names of the rules a CAN can be created with for splitting a zone between its owner and a joiner
+const (
+	SplitLongest = "longest" // Halve the longest edge
+	SplitOrdered = "ordered" // Halve each dimension in turn, by how many times the zone has been split
+	SplitMedian  = "median"  // Cut the longest edge at the median coordinate of the zone's keys
+)
+
+// DefaultSplit - Split strategy used when none is chosen
+const DefaultSplit = SplitLongest
+
+// CheckSplit - Ensure a split strategy is known, an empty name means the default strategy
+func CheckSplit(name string) error {
+	switch name {
+	case "", SplitLongest, SplitOrdered, SplitMedian:
+		return nil
+	}
+	return errors.New("Unknown split strategy " + strconv.Quote(name))
+}
+
+// SplitNames - List the names of all split strategies
+func SplitNames() []string {
+	return []string{SplitLongest, SplitOrdered, SplitMedian}
+}
+
+// SplitStep - One split in the history of a zone
+//
+// Lo and Hi bound the parent zone along Axis, so a zone can be merged back with its sibling
+// whichever strategy cut it.
+type SplitStep struct {
+	Axis  int
+	At    float64 // Coordinate of the cut
+	Lo    float64
+	Hi    float64
+	Upper bool // The zone kept the half above the cut
+}
+
+// GetSplitStepResponse - Marshal a split step into a transmittable JSON form
+func (st *SplitStep) GetSplitStepResponse() data.SplitStepResponse {
+	return data.SplitStepResponse{
+		Axis:  st.Axis,
+		At:    st.At,
+		Lo:    st.Lo,
+		Hi:    st.Hi,
+		Upper: st.Upper,
+	}
+}
+
+// UnpackHistory - Take a transmitted split history into split steps
+func UnpackHistory(res []data.SplitStepResponse) []SplitStep {
+	history := make([]SplitStep, 0, len(res))
+	for _, st := range res {
+		history = append(history, SplitStep{Axis: st.Axis, At: st.At, Lo: st.Lo, Hi: st.Hi, Upper: st.Upper})
+	}
+	return history
+}
+
+// GetHistoryResponse - Marshal the region's split history into a transmittable JSON form
+func (r *Region) GetHistoryResponse() []data.SplitStepResponse {
+	history := r.Geometry().History
+	res := make([]data.SplitStepResponse, 0, len(history))
+	for _, st := range history {
+		res = append(res, st.GetSplitStepResponse())
+	}
+	return res
+}
+
+// longestAxis - Find the dimension along which a range is longest, the first if several tie
+func (r *Range) longestAxis() int {
+	dims := r.Dimensions().Coords
+	axis := 0
+	for i, val := range dims {
+		if val > dims[axis] {
+			axis = i
+		}
+	}
+	return axis
+}
+
+// chooseSplit - Pick the axis and coordinate of the next split under the region's strategy, the caller
+// must hold the lock
+func (r *Region) chooseSplit() (int, float64) {
+	lo, hi := r.Space.P1.Coords, r.Space.P2.Coords
+
+	// The zone ID tells which edge is longest even once the floating point bounds can no longer
+	if axes := r.geometry().axes; axes != nil {
+		axis := nextAxis(axes, len(r.Zone), r.SplitStrategy)
+		return axis, (lo[axis] + hi[axis]) / 2
+	}
+
+	switch r.SplitStrategy {
+	case SplitOrdered:
+		axis := len(r.History) % r.Dimension
+		return axis, (lo[axis] + hi[axis]) / 2
+	case SplitMedian:
+		axis := r.Space.longestAxis()
+		if at, ok := r.medianCoord(axis); ok {
+			return axis, at
+		}
+		return axis, (lo[axis] + hi[axis]) / 2
+	}
+	axis := r.Space.longestAxis()
+	return axis, (lo[axis] + hi[axis]) / 2
+}
+
+// medianCoord - Find a cut along an axis leaving half of the region's keys on each side, false if
+// the keys cannot be divided by a cut strictly inside the zone
+func (r *Region) medianCoord(axis int) (float64, bool) {
+	now := time.Now()
+	coords := make([]float64, 0, len(r.Data))
+	for key, rec := range r.Data {
+		if !rec.Expired(now) {
+			coords = append(coords, r.HashKey(key).Coords[axis])
+		}
+	}
+	if len(coords) < 2 {
+		return 0, false
+	}
+	sort.Float64s(coords)
+
+	// Cut between the two middle keys, so they fall on opposite sides
+	mid := len(coords) / 2
+	at := (coords[mid-1] + coords[mid]) / 2
+	if at <= r.Space.P1.Coords[axis] || at >= r.Space.P2.Coords[axis] {
+		return 0, false
+	}
+	return at, true
+}
+
+// Parent - The zone this region was split from, false if it has never been split
+func (r *Region) Parent() (*Range, bool) {
+	return r.Geometry().parent()
+}
+
+// parent - The zone this one was split from, false if it has never been split
+func (g *Geometry) parent() (*Range, bool) {
+	if len(g.History) == 0 {
+		return nil, false
+	}
+	last := g.History[len(g.History)-1]
+	parent := g.Space.Copy()
+	parent.P1.Coords[last.Axis] = last.Lo
+	parent.P2.Coords[last.Axis] = last.Hi
+	return parent, true
+}
+
+// Sibling - The other half of the split which created this region, false if it has never been split
+//
+// A leaving server merges back into the server whose zone is exactly its sibling.
+func (r *Region) Sibling() (*Range, bool) {
+	geo := r.Geometry()
+	sibling, ok := geo.parent()
+	if !ok {
+		return nil, false
+	}
+	last := geo.History[len(geo.History)-1]
+	if last.Upper {
+		sibling.P2.Coords[last.Axis] = last.At
+	} else {
+		sibling.P1.Coords[last.Axis] = last.At
+	}
+	return sibling, true
+}
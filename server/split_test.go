@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCheckSplit(t *testing.T) {
+	for _, name := range append(SplitNames(), "") {
+		if err := CheckSplit(name); err != nil {
+			t.Errorf("CheckSplit(%q) failed with %v", name, err)
+		}
+	}
+	if err := CheckSplit("diagonal"); err == nil {
+		t.Error("CheckSplit accepted an unknown strategy")
+	}
+}
+
+func TestSplitStrategies(t *testing.T) {
+	rng := func(p1, p2 []float64) Range { return Range{P1: Point{p1}, P2: Point{p2}} }
+
+	tests := []struct {
+		name      string
+		strategy  string
+		dim       int
+		splits    int
+		kept, new Range // After the last split
+	}{
+		{
+			name: "longest halves the first axis", strategy: SplitLongest, dim: 2, splits: 1,
+			kept: rng([]float64{0, 0}, []float64{0.5, 1}), new: rng([]float64{0.5, 0}, []float64{1, 1}),
+		},
+		{
+			name: "longest then halves the other axis", strategy: SplitLongest, dim: 2, splits: 2,
+			kept: rng([]float64{0, 0}, []float64{0.5, 0.5}), new: rng([]float64{0, 0.5}, []float64{0.5, 1}),
+		},
+		{
+			name: "longest returns to the first axis", strategy: SplitLongest, dim: 2, splits: 3,
+			kept: rng([]float64{0, 0}, []float64{0.25, 0.5}), new: rng([]float64{0.25, 0}, []float64{0.5, 0.5}),
+		},
+		{
+			name: "ordered takes the axes in turn", strategy: SplitOrdered, dim: 3, splits: 3,
+			kept: rng([]float64{0, 0, 0}, []float64{0.5, 0.5, 0.5}), new: rng([]float64{0, 0, 0.5}, []float64{0.5, 0.5, 1}),
+		},
+		{
+			name: "median halves a zone without keys", strategy: SplitMedian, dim: 2, splits: 1,
+			kept: rng([]float64{0, 0}, []float64{0.5, 1}), new: rng([]float64{0.5, 0}, []float64{1, 1}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher, _ := GetHasher(DefaultHasher)
+			reg := CreateRegion(tt.dim, 1, hasher, PlacementHashed)
+			reg.SplitStrategy = tt.strategy
+
+			var newReg *Region
+			for i := 0; i < tt.splits; i++ {
+				parent := reg.Space
+				if newReg, _ = reg.Split(Neighbor{ID: "me"}); newReg == nil {
+					t.Fatalf("Split %d failed", i)
+				}
+
+				// Either half can rebuild the zone it was cut from, and names the other as its sibling
+				if back, ok := newReg.Parent(); !ok || !back.Equal(&parent) {
+					t.Fatalf("Split %d has parent %v, want %v", i, back, parent)
+				}
+				if sibling, ok := newReg.Sibling(); !ok || !sibling.Equal(&reg.Space) {
+					t.Fatalf("Split %d has sibling %v, want %v", i, sibling, reg.Space)
+				}
+			}
+			if !reg.Space.Equal(&tt.kept) {
+				t.Fatalf("Kept %v, want %v", reg.Space, tt.kept)
+			}
+			if !newReg.Space.Equal(&tt.new) {
+				t.Fatalf("Handed out %v, want %v", newReg.Space, tt.new)
+			}
+		})
+	}
+}
+
+func TestMedianSplitDividesKeys(t *testing.T) {
+	for _, keys := range []int{2, 3, 10, 101} {
+		t.Run(fmt.Sprint(keys), func(t *testing.T) {
+			hasher, _ := GetHasher(DefaultHasher)
+			reg := CreateRegion(2, 1, hasher, PlacementHashed)
+			reg.SplitStrategy = SplitMedian
+			for k := 0; k < keys; k++ {
+				reg.Data[fmt.Sprint("key-", k)] = NewRecord([]byte("v"), DefaultTextType)
+			}
+
+			newReg, _ := reg.Split(Neighbor{ID: "me"})
+			if newReg == nil {
+				t.Fatal("Split failed")
+			}
+			if kept, handed := len(reg.Data), len(newReg.Data); kept != keys/2 || handed != keys-keys/2 {
+				t.Fatalf("Split %d keys into %d kept and %d handed out", keys, kept, handed)
+			}
+			if step := newReg.History[0]; step.At == 0.5 || step.At <= 0 || step.At >= 1 {
+				t.Fatalf("Cut at %v, want a median strictly inside the zone", step.At)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"main/data"
+)
+
+// ConfigureTLS - Serve and send all traffic over HTTPS using a certificate and key, trusting the
+// CA bundle if given. With mutual set, our certificate is also presented to other servers and
+// membership requests must come with a client certificate signed by the CA.
+//
+// Returns the TLS configuration for the listener.
+func (s *Server) ConfigureTLS(certFile, keyFile, caFile string, mutual bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool *x509.CertPool
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in CA bundle " + caFile)
+		}
+	} else if mutual {
+		return nil, errors.New("Mutual TLS requires a CA bundle to verify other servers")
+	}
+
+	// Outgoing requests trust the CA bundle, falling back to the system roots
+	clientConf := &tls.Config{
+		RootCAs: pool,
+	}
+	if mutual {
+		clientConf.Certificates = []tls.Certificate{cert}
+	}
+	s.C.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: clientConf,
+	}
+
+	// Clients without certificates may still use the data endpoints
+	serverConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if mutual {
+		serverConf.ClientCAs = pool
+		serverConf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	s.Scheme = "https"
+	s.MutualTLS = mutual
+	return serverConf, nil
+}
+
+// RequireClientCert - Middleware rejecting requests without a verified client certificate when mutual TLS is on
+func (s *Server) RequireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.MutualTLS && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			log.Warn("Rejected request to " + r.URL.Path + " without a cluster certificate")
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(&data.ErrorResponse{Message: "A cluster certificate is required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
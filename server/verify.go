@@ -0,0 +1,315 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+
+	"main/data"
+)
+
+// Checks made by the verifier
+const (
+	CheckReachable = "reachable" // Every listed neighbor answers /debug
+	CheckConfig    = "config"    // Every server agrees on the parameters of the CAN
+	CheckTiling    = "tiling"    // Zones cover the unit space exactly once
+	CheckSymmetry  = "symmetry"  // Neighbors list each other with their current address and range
+	CheckAdjacency = "adjacency" // Neighbor tables hold exactly the zones sharing a face
+	CheckKeys      = "keys"      // Every key hashes into the zone of the server holding it
+)
+
+// volumeTolerance - Error allowed in sums of zone volumes
+const volumeTolerance = 1e-9
+
+// Crawl - Fetch /debug from every server reachable from start through neighbor tables, by node ID
+//
+// Neighbors which cannot be fetched are reported as violations.
+func Crawl(c *http.Client, start Host) (map[string]*data.DebugResponse, []data.Violation) {
+	found := make(map[string]*data.DebugResponse)
+	violations := []data.Violation{}
+
+	type visit struct {
+		host Host
+		from string // Server whose neighbor table named this one, empty for start
+		id   string
+	}
+	queue := []visit{{host: start}}
+	queued := make(map[string]bool)
+
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		dRes, err := fetchDebug(c, next.host)
+		if err != nil {
+			violations = append(violations, data.Violation{
+				Check:   CheckReachable,
+				Node:    next.from,
+				Other:   next.id,
+				Message: "Could not fetch /debug from " + next.host.String() + ": " + err.Error(),
+			})
+			continue
+		}
+		queued[dRes.ID] = true
+		found[dRes.ID] = dRes
+
+		for id, neighbor := range dRes.Neighbors {
+			if !queued[id] {
+				queued[id] = true
+				queue = append(queue, visit{host: ParseHost(neighbor.Address, ""), from: dRes.ID, id: id})
+			}
+		}
+	}
+	return found, violations
+}
+
+// fetchDebug - Request the state of a single server
+func fetchDebug(c *http.Client, hst Host) (*data.DebugResponse, error) {
+	resp, err := c.Get(hst.URL("/debug"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	dRes := &data.DebugResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(dRes); err != nil {
+		return nil, err
+	}
+	return dRes, nil
+}
+
+// Verify - Check the invariants of a CAN over snapshots of its servers keyed by node ID
+func Verify(snapshots map[string]*data.DebugResponse) *data.VerifyResponse {
+	vRes := &data.VerifyResponse{
+		Nodes:      len(snapshots),
+		Violations: []data.Violation{},
+	}
+	report := func(v data.Violation) {
+		vRes.Violations = append(vRes.Violations, v)
+	}
+
+	// Visit servers in a fixed order so reports are stable
+	ids := make([]string, 0, len(snapshots))
+	for id := range snapshots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	regions := make(map[string]*Region)
+	var first *data.DebugResponse
+	for _, id := range ids {
+		snap := snapshots[id]
+		if first == nil {
+			first = snap
+		}
+		if snap.Dimension != first.Dimension || snap.Hasher != first.Hasher ||
+			snap.Placement != first.Placement || snap.Torus != first.Torus || snap.Split != first.Split {
+			report(data.Violation{
+				Check:   CheckConfig,
+				Node:    id,
+				Other:   first.ID,
+				Message: "Parameters of the CAN differ between servers",
+			})
+			continue
+		}
+
+		hasher, err := GetHasher(snap.Hasher)
+		if err != nil {
+			report(data.Violation{Check: CheckConfig, Node: id, Message: err.Error()})
+			continue
+		}
+		regions[id] = &Region{
+			Dimension:     snap.Dimension,
+			Space:         *UnpackRange(snap.Range),
+			Zone:          ZoneID(snap.Zone),
+			Hasher:        hasher,
+			Placement:     snap.Placement,
+			Torus:         snap.Torus,
+			SplitStrategy: snap.Split,
+		}
+	}
+
+	// Zones tile the space when none overlap and their volumes add up to the whole space
+	for i, id := range ids {
+		reg, prs := regions[id]
+		if !prs {
+			continue
+		}
+		if !reg.Space.insideUnit() {
+			report(data.Violation{
+				Check:   CheckTiling,
+				Node:    id,
+				Message: fmt.Sprintf("Zone %v extends outside the unit space", reg.Space),
+			})
+		}
+		if _, ok := exactAxes(reg.Zone, &reg.Space, reg.Dimension, reg.SplitStrategy); !ok && Dyadic(reg.SplitStrategy) {
+			report(data.Violation{
+				Check:   CheckTiling,
+				Node:    id,
+				Message: fmt.Sprintf("Zone ID %q does not describe zone %v", reg.Zone, reg.Space),
+			})
+		}
+		vRes.Volume += reg.Space.Volume()
+
+		for _, otherID := range ids[i+1:] {
+			other, prs := regions[otherID]
+			if !prs {
+				continue
+			}
+			if overlap := reg.Space.overlapVolume(&other.Space); overlap > volumeTolerance {
+				report(data.Violation{
+					Check:   CheckTiling,
+					Node:    id,
+					Other:   otherID,
+					Message: fmt.Sprintf("Zones overlap by volume %g", overlap),
+				})
+			}
+
+			// Each pair of servers sharing a face must list each other, and no other pairs may
+			adjacent := reg.Borders(other.Zone, &other.Space)
+			for _, pair := range [][2]string{{id, otherID}, {otherID, id}} {
+				_, listed := snapshots[pair[0]].Neighbors[pair[1]]
+				if adjacent && !listed {
+					report(data.Violation{
+						Check:   CheckAdjacency,
+						Node:    pair[0],
+						Other:   pair[1],
+						Message: "Adjacent zone is missing from the neighbor table",
+					})
+				} else if !adjacent && listed {
+					report(data.Violation{
+						Check:   CheckAdjacency,
+						Node:    pair[0],
+						Other:   pair[1],
+						Message: "Neighbor table lists a zone which is not adjacent",
+					})
+				}
+			}
+		}
+	}
+	if len(regions) == len(snapshots) && math.Abs(vRes.Volume-1) > volumeTolerance {
+		report(data.Violation{
+			Check:   CheckTiling,
+			Message: fmt.Sprintf("Zones cover volume %g of the unit space", vRes.Volume),
+		})
+	}
+
+	for _, id := range ids {
+		snap := snapshots[id]
+
+		// What a server believes of its neighbors must match what they report themselves
+		neighborIDs := make([]string, 0, len(snap.Neighbors))
+		for nid := range snap.Neighbors {
+			neighborIDs = append(neighborIDs, nid)
+		}
+		sort.Strings(neighborIDs)
+		for _, nid := range neighborIDs {
+			neighbor := snap.Neighbors[nid]
+			other, prs := snapshots[nid]
+			if !prs {
+				continue
+			}
+			if _, back := other.Neighbors[id]; !back {
+				report(data.Violation{
+					Check:   CheckSymmetry,
+					Node:    id,
+					Other:   nid,
+					Message: "Neighbor does not list this server in return",
+				})
+			}
+			if rangeKey(UnpackRange(neighbor.Range)) != rangeKey(UnpackRange(other.Range)) || neighbor.Zone != other.Zone {
+				report(data.Violation{
+					Check:   CheckSymmetry,
+					Node:    id,
+					Other:   nid,
+					Message: "Neighbor table holds a stale range for this neighbor",
+				})
+			}
+			// IPs depend on where a server is seen from, so only the port and scheme must agree
+			listed, actual := ParseHost(neighbor.Address, ""), ParseHost(other.Address, "")
+			if listed.Port != actual.Port || listed.Scheme != actual.Scheme {
+				report(data.Violation{
+					Check:   CheckSymmetry,
+					Node:    id,
+					Other:   nid,
+					Message: "Neighbor table holds address " + neighbor.Address + " but the neighbor reports " + other.Address,
+				})
+			}
+		}
+
+		reg, prs := regions[id]
+		if !prs {
+			continue
+		}
+		keys := make([]string, 0, len(snap.Data))
+		for key := range snap.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		vRes.Keys += len(keys)
+		for _, key := range keys {
+			if !reg.Owns(reg.HashKey(key)) {
+				report(data.Violation{
+					Check:   CheckKeys,
+					Node:    id,
+					Key:     key,
+					Message: "Key hashes outside the zone of the server holding it",
+				})
+			}
+		}
+	}
+
+	vRes.OK = len(vRes.Violations) == 0
+	return vRes
+}
+
+// VerifyFrom - Crawl the CAN from start and check its invariants, including that every server is reachable
+func VerifyFrom(c *http.Client, start Host) *data.VerifyResponse {
+	snapshots, unreachable := Crawl(c, start)
+	vRes := Verify(snapshots)
+	vRes.Violations = append(unreachable, vRes.Violations...)
+	vRes.OK = len(vRes.Violations) == 0
+	return vRes
+}
+
+// insideUnit - Determine if a range lies within the unit space
+func (r *Range) insideUnit() bool {
+	for i := range r.P1.Coords {
+		if r.P1.Coords[i] < 0 || r.P2.Coords[i] > 1 || r.P1.Coords[i] >= r.P2.Coords[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapVolume - Returns the volume of the intersection of two ranges
+func (r *Range) overlapVolume(other *Range) float64 {
+	vol := 1.0
+	for i := range r.P1.Coords {
+		lo := math.Max(r.P1.Coords[i], other.P1.Coords[i])
+		hi := math.Min(r.P2.Coords[i], other.P2.Coords[i])
+		if hi <= lo {
+			return 0
+		}
+		vol *= hi - lo
+	}
+	return vol
+}
+
+// VerifyCAN - Crawl the CAN from this server and report any broken invariants
+func (s *Server) VerifyCAN(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered VerifyCAN method")
+	w.Header().Add("Content-Type", "application/json")
+
+	vRes := VerifyFrom(s.C, s.Self(r).Host)
+
+	log.Info("Sending VerifyCAN response")
+	json.NewEncoder(w).Encode(vRes)
+
+	log.Info("Exiting VerifyCAN method")
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nextVersion - Return a version greater than prev, using the clock so versions keep increasing
+// when a key is deleted and created again
+func nextVersion(prev uint64) uint64 {
+	now := uint64(time.Now().UnixNano())
+	if now > prev {
+		return now
+	}
+	return prev + 1
+}
+
+// ETag - Format a record version as an HTTP entity tag
+func ETag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// Precondition - Versions a record must or must not have for a write to go ahead
+type Precondition struct {
+	Match        []uint64 // Record must exist with one of these versions
+	MatchAny     bool     // Record must exist
+	NoneMatch    []uint64 // Record must not have any of these versions
+	NoneMatchAny bool     // Record must not exist
+}
+
+// Check - Determine if a record satisfies the precondition, exists is false if there is no record
+func (p *Precondition) Check(rec *Record, exists bool) bool {
+	if p.MatchAny && !exists {
+		return false
+	}
+	if len(p.Match) > 0 && (!exists || !containsVersion(p.Match, rec.Version)) {
+		return false
+	}
+	if p.NoneMatchAny && exists {
+		return false
+	}
+	if exists && containsVersion(p.NoneMatch, rec.Version) {
+		return false
+	}
+	return true
+}
+
+// ParsePrecondition - Read If-Match and If-None-Match headers, along with a version from a request body
+//
+// A version of 0 in the body requires that the key does not exist, any other version must match.
+func ParsePrecondition(r *http.Request, version *uint64) (Precondition, error) {
+	var cond Precondition
+	var err error
+
+	if cond.Match, cond.MatchAny, err = parseETags(r.Header.Get("If-Match")); err != nil {
+		return cond, err
+	}
+	if cond.NoneMatch, cond.NoneMatchAny, err = parseETags(r.Header.Get("If-None-Match")); err != nil {
+		return cond, err
+	}
+
+	if version != nil {
+		if *version == 0 {
+			cond.NoneMatchAny = true
+		} else {
+			cond.Match = append(cond.Match, *version)
+		}
+	}
+
+	return cond, nil
+}
+
+// parseETags - Parse a comma separated list of entity tags into versions, any is set for "*"
+func parseETags(header string) (versions []uint64, any bool, err error) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "" {
+			continue
+		}
+		if tag == "*" {
+			any = true
+			continue
+		}
+
+		version, err := strconv.ParseUint(strings.Trim(tag, `"`), 10, 64)
+		if err != nil {
+			return nil, false, errors.New("Invalid entity tag " + tag)
+		}
+		versions = append(versions, version)
+	}
+	return versions, any, nil
+}
+
+// containsVersion - Determine if a version is in a list of versions
+func containsVersion(versions []uint64, version uint64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPreconditionCheck(t *testing.T) {
+	rec := &Record{Version: 7}
+
+	tests := []struct {
+		name   string
+		cond   Precondition
+		exists bool
+		want   bool
+	}{
+		{name: "none", exists: false, want: true},
+		{name: "none on record", exists: true, want: true},
+		{name: "match", cond: Precondition{Match: []uint64{3, 7}}, exists: true, want: true},
+		{name: "match stale", cond: Precondition{Match: []uint64{3}}, exists: true, want: false},
+		{name: "match missing", cond: Precondition{Match: []uint64{7}}, exists: false, want: false},
+		{name: "match any", cond: Precondition{MatchAny: true}, exists: true, want: true},
+		{name: "match any missing", cond: Precondition{MatchAny: true}, exists: false, want: false},
+		{name: "none match", cond: Precondition{NoneMatch: []uint64{3}}, exists: true, want: true},
+		{name: "none match current", cond: Precondition{NoneMatch: []uint64{7}}, exists: true, want: false},
+		{name: "none match missing", cond: Precondition{NoneMatch: []uint64{7}}, exists: false, want: true},
+		{name: "none match any", cond: Precondition{NoneMatchAny: true}, exists: true, want: false},
+		{name: "none match any missing", cond: Precondition{NoneMatchAny: true}, exists: false, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cond.Check(rec, tt.exists); got != tt.want {
+				t.Errorf("Check(exists=%v) is %v, want %v", tt.exists, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrecondition(t *testing.T) {
+	zero, five := uint64(0), uint64(5)
+
+	tests := []struct {
+		name        string
+		ifMatch     string
+		ifNoneMatch string
+		version     *uint64
+		want        Precondition
+		ok          bool
+	}{
+		{name: "empty", ok: true},
+		{name: "if match", ifMatch: `"3", W/"4"`, want: Precondition{Match: []uint64{3, 4}}, ok: true},
+		{name: "if match any", ifMatch: "*", want: Precondition{MatchAny: true}, ok: true},
+		{name: "if none match", ifNoneMatch: `"9"`, want: Precondition{NoneMatch: []uint64{9}}, ok: true},
+		{name: "if none match any", ifNoneMatch: " * ", want: Precondition{NoneMatchAny: true}, ok: true},
+		{name: "body create", version: &zero, want: Precondition{NoneMatchAny: true}, ok: true},
+		{name: "body version", version: &five, want: Precondition{Match: []uint64{5}}, ok: true},
+		{name: "header and body", ifMatch: `"3"`, version: &five, want: Precondition{Match: []uint64{3, 5}}, ok: true},
+		{name: "bad if match", ifMatch: `"abc"`},
+		{name: "bad if none match", ifNoneMatch: `"-1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/data/key", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			got, err := ParsePrecondition(r, tt.version)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParsePrecondition returned %v", err)
+			}
+			if tt.ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePrecondition is %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestETagRoundTrip(t *testing.T) {
+	for _, version := range []uint64{0, 1, 1<<64 - 1} {
+		versions, any, err := parseETags(ETag(version))
+		if err != nil || any || len(versions) != 1 || versions[0] != version {
+			t.Errorf("ETag(%d) = %s parsed to %v, %v, %v", version, ETag(version), versions, any, err)
+		}
+	}
+}
+
+func TestNextVersionIncreases(t *testing.T) {
+	for _, prev := range []uint64{0, 1 << 63, 1<<64 - 2} {
+		if got := nextVersion(prev); got <= prev {
+			t.Errorf("nextVersion(%d) is %d", prev, got)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"main/data"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Types of events sent to watchers
+const (
+	EventPut    = "put"
+	EventPatch  = "patch"
+	EventDelete = "delete"
+	EventExpire = "expire"
+	EventMoved  = "moved" // The watched key moved to another server, reconnect at the given location
+	EventSplit  = "split" // The watched zone shrank after a join
+)
+
+// watchBuffer - Events queued for a watcher before it is considered too slow and dropped
+const watchBuffer = 64
+
+// keepaliveInterval - Time between comments sent to keep idle event streams open
+const keepaliveInterval = 15 * time.Second
+
+// watcher - A single client stream waiting for changes
+type watcher struct {
+	key    string // Watch a single key
+	prefix string // Watch every key in our zone with this prefix
+	zone   bool   // Watch every key in our zone
+	events chan data.WatchEvent
+	done   chan struct{} // Closed when the hub stops sending to this watcher
+}
+
+// matches - Determine if a change to key concerns this watcher
+func (wt *watcher) matches(key string) bool {
+	if wt.key != "" {
+		return wt.key == key
+	}
+	return strings.HasPrefix(key, wt.prefix)
+}
+
+// WatchHub - Tracks watchers and fans out changes to the data in a region
+type WatchHub struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+// NewWatchHub - Create a hub with no watchers
+func NewWatchHub() *WatchHub {
+	return &WatchHub{
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+// subscribe - Start sending events to a watcher
+func (h *WatchHub) subscribe(wt *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watchers[wt] = struct{}{}
+}
+
+// unsubscribe - Stop sending events to a watcher
+func (h *WatchHub) unsubscribe(wt *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(wt)
+}
+
+// remove - Drop a watcher, the caller must hold the lock
+func (h *WatchHub) remove(wt *watcher) {
+	if _, prs := h.watchers[wt]; prs {
+		delete(h.watchers, wt)
+		close(wt.done)
+	}
+}
+
+// send - Queue an event for a watcher, dropping watchers which have fallen behind
+func (h *WatchHub) send(wt *watcher, ev data.WatchEvent) {
+	select {
+	case wt.events <- ev:
+	default:
+		log.Warn("Dropping watcher which is not keeping up with events")
+		h.remove(wt)
+	}
+}
+
+// Publish - Send a change to a key to every watcher interested in it
+func (h *WatchHub) Publish(evType, key string, rec *Record) {
+	ev := data.WatchEvent{
+		Type: evType,
+		Key:  key,
+	}
+	if rec != nil {
+		ev.Record = rec.GetRecordResponse()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for wt := range h.watchers {
+		if wt.matches(key) {
+			h.send(wt, ev)
+		}
+	}
+}
+
+// Handoff - After a join splits our region, send watchers of keys which moved to the joiner
+// at location, and tell zone and prefix watchers about our smaller zone
+func (h *WatchHub) Handoff(reg *Region, location string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	geo := reg.Geometry()
+	rng := geo.Space.GetRangeResponse()
+	for wt := range h.watchers {
+		if wt.key == "" {
+			h.send(wt, data.WatchEvent{Type: EventSplit, Range: rng})
+			continue
+		}
+
+		if !geo.Owns(reg.HashKey(wt.key)) {
+			h.send(wt, data.WatchEvent{
+				Type:     EventMoved,
+				Key:      wt.key,
+				Location: location + "/watch?key=" + url.QueryEscape(wt.key),
+			})
+			h.remove(wt)
+		}
+	}
+}
+
+// Watch - Stream changes to a key, a prefix or our whole zone as Server-Sent Events
+func (s *Server) Watch(w http.ResponseWriter, r *http.Request) {
+	log.Info("Entered Watch method")
+
+	query := r.URL.Query()
+	wt := &watcher{
+		key:    query.Get("key"),
+		prefix: query.Get("prefix"),
+		zone:   query.Get("zone") == "true",
+		events: make(chan data.WatchEvent, watchBuffer),
+		done:   make(chan struct{}),
+	}
+
+	var err error
+	if (wt.key != "") == (wt.prefix != "" || wt.zone) {
+		err = errors.New("Watch exactly one of key, prefix or zone=true")
+	}
+	flusher, ok := w.(http.Flusher)
+	if err == nil && !ok {
+		err = errors.New("Streaming is not supported by this connection")
+	}
+	if err != nil {
+		log.Warn(err)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&data.ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	// Watches on a single key are held open by the server owning it
+	if wt.key != "" {
+		pt := s.Reg.HashKey(wt.key)
+		if inReg, neighbor := s.Reg.Locate(pt); !inReg {
+			log.WithFields(logrus.Fields{
+				"IP":   neighbor.IP,
+				"Port": neighbor.Port,
+			}).Info("Redirecting Watch request to neighbor")
+
+			http.Redirect(w, r, neighbor.URL("/watch?"+r.URL.RawQuery), http.StatusTemporaryRedirect)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.Watchers.subscribe(wt)
+	defer s.Watchers.unsubscribe(wt)
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Info("Exiting Watch method")
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case ev := <-wt.events:
+			body, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, body)
+			flusher.Flush()
+		case <-wt.done:
+			// Deliver anything queued before the hub let go of us, such as a move
+			for len(wt.events) > 0 {
+				ev := <-wt.events
+				body, _ := json.Marshal(ev)
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, body)
+			}
+			flusher.Flush()
+			log.Info("Exiting Watch method")
+			return
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"math"
+	"math/big"
+	"strings"
+)
+
+// ZoneID - The path of splits which produced a zone, a '0' each time it kept the lower half and a '1'
+// each time it kept the upper half, empty for the whole space
+type ZoneID string
+
+// Child - The ID of one half of a zone
+func (z ZoneID) Child(upper bool) ZoneID {
+	if upper {
+		return z + "1"
+	}
+	return z + "0"
+}
+
+// Parent - The ID of the zone this one was split from, false for the whole space
+func (z ZoneID) Parent() (ZoneID, bool) {
+	if z == "" {
+		return "", false
+	}
+	return z[:len(z)-1], true
+}
+
+// Sibling - The ID of the other half of the split which produced this zone, false for the whole space
+func (z ZoneID) Sibling() (ZoneID, bool) {
+	parent, ok := z.Parent()
+	if !ok {
+		return "", false
+	}
+	return parent.Child(z[len(z)-1] == '0'), true
+}
+
+// Valid - Determine if an ID holds only binary digits
+func (z ZoneID) Valid() bool {
+	return strings.Trim(string(z), "01") == ""
+}
+
+// Dyadic - Determine if zones made by a split strategy always halve their parent, so their IDs
+// fix their bounds exactly
+func Dyadic(strategy string) bool {
+	return strategy != SplitMedian
+}
+
+// nextAxis - The axis the next split of a zone halves, given the bits of the zone along each axis
+func nextAxis(axes []string, depth int, strategy string) int {
+	if strategy == SplitOrdered {
+		return depth % len(axes)
+	}
+
+	// The longest edge has been halved the fewest times
+	axis := 0
+	for i, bits := range axes {
+		if len(bits) < len(axes[axis]) {
+			axis = i
+		}
+	}
+	return axis
+}
+
+// zoneAxes - Decode a zone ID into the binary expansion of the zone's lower bound along each axis,
+// false unless the strategy always halves zones
+func zoneAxes(z ZoneID, dim int, strategy string) ([]string, bool) {
+	if !Dyadic(strategy) || !z.Valid() || dim < 1 {
+		return nil, false
+	}
+	axes := make([]string, dim)
+	for i := 0; i < len(z); i++ {
+		axis := nextAxis(axes, i, strategy)
+		axes[axis] += string(z[i])
+	}
+	return axes, true
+}
+
+// ZoneRange - The bounds of a zone made by halving, false if the strategy does not always halve
+//
+// Bounds are exact while a zone has been halved fewer than 53 times along each axis, beyond that
+// they are the nearest float64 values.
+func ZoneRange(z ZoneID, dim int, strategy string) (*Range, bool) {
+	axes, ok := zoneAxes(z, dim, strategy)
+	if !ok {
+		return nil, false
+	}
+	rng := &Range{P1: Point{make([]float64, dim)}, P2: Point{make([]float64, dim)}}
+	for i, bits := range axes {
+		lo := 0.0
+		for j := len(bits) - 1; j >= 0; j-- {
+			lo = (lo + float64(bits[j]-'0')) / 2
+		}
+		rng.P1.Coords[i] = lo
+		rng.P2.Coords[i] = lo + math.Ldexp(1, -len(bits))
+	}
+	return rng, true
+}
+
+// coordBits - The first n binary digits of a coordinate in [0,1), computed exactly
+func coordBits(x float64, n int) string {
+	bits := make([]byte, n)
+	for i := range bits {
+		x *= 2
+		if x >= 1 {
+			bits[i] = '1'
+			x--
+		} else {
+			bits[i] = '0'
+		}
+	}
+	return string(bits)
+}
+
+// axesContain - Determine exactly if a point lies in a zone given by its bits along each axis
+func axesContain(axes []string, pt Point) bool {
+	for i, bits := range axes {
+		if pt.Coords[i] < 0 || pt.Coords[i] >= 1 || coordBits(pt.Coords[i], len(bits)) != bits {
+			return false
+		}
+	}
+	return true
+}
+
+// bitsValue - The value of a binary digit string as an integer
+func bitsValue(bits string) *big.Int {
+	val := new(big.Int)
+	if bits != "" {
+		val.SetString(bits, 2)
+	}
+	return val
+}
+
+// touches - Determine exactly if the upper end of interval a meets the lower end of interval b, each
+// given by the bits of its lower bound
+func touches(a, b string) bool {
+	// (a+1)/2^len(a) == b/2^len(b)
+	hi := new(big.Int).Add(bitsValue(a), big.NewInt(1))
+	hi.Lsh(hi, uint(len(b)))
+	lo := bitsValue(b)
+	lo.Lsh(lo, uint(len(a)))
+	return hi.Cmp(lo) == 0
+}
+
+// axesBorder - Determine exactly if two zones share a face, touching along one axis and overlapping
+// along every other, wrapping around the edges of the space on a torus
+func axesBorder(a, b []string, torus bool) bool {
+	touching := 0
+	for i := range a {
+		switch {
+		case strings.HasPrefix(a[i], b[i]) || strings.HasPrefix(b[i], a[i]):
+			// Intervals from the same binary subdivision overlap exactly when one contains the other
+		case touches(a[i], b[i]) || touches(b[i], a[i]):
+			touching++
+		case torus && (allOnes(a[i]) && allZeros(b[i]) || allOnes(b[i]) && allZeros(a[i])):
+			touching++
+		default:
+			return false
+		}
+	}
+	return touching == 1
+}
+
+// allOnes - Determine if a non-empty bit string reaches the upper edge of the space
+func allOnes(bits string) bool {
+	return bits != "" && strings.Trim(bits, "1") == ""
+}
+
+// allZeros - Determine if a non-empty bit string starts at the lower edge of the space
+func allZeros(bits string) bool {
+	return bits != "" && strings.Trim(bits, "0") == ""
+}
+
+// exactAxes - Decode a zone ID whose bounds it fixes, false if the ID does not describe the range,
+// as for neighbors which did not send one
+func exactAxes(z ZoneID, space *Range, dim int, strategy string) ([]string, bool) {
+	axes, ok := zoneAxes(z, dim, strategy)
+	if !ok {
+		return nil, false
+	}
+	if rng, _ := ZoneRange(z, dim, strategy); !rng.Equal(space) {
+		return nil, false
+	}
+	return axes, true
+}
+
+// Geometry - A region's zone as it stood at one moment, unaffected by splits applied afterwards
+//
+// Snapshots are shared and must not be modified.
+type Geometry struct {
+	Space   Range
+	Zone    ZoneID
+	Epoch   uint64
+	History []SplitStep
+
+	dim      int
+	strategy string
+	torus    bool
+	axes     []string // Bits of the zone along each axis, nil unless the zone ID fixes its bounds
+}
+
+// Geometry - Take a snapshot of the region's zone, consistent even while a split is being applied
+func (r *Region) Geometry() *Geometry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.geometry()
+}
+
+// geometry - The snapshot of the region's current zone, decoded again only when the zone has changed,
+// the caller must hold the lock
+func (r *Region) geometry() *Geometry {
+	if g, ok := r.geo.Load().(*Geometry); ok && g.Zone == r.Zone && g.Epoch == r.Epoch && g.Space.Equal(&r.Space) {
+		return g
+	}
+	g := &Geometry{
+		Space:    *r.Space.Copy(),
+		Zone:     r.Zone,
+		Epoch:    r.Epoch,
+		History:  append([]SplitStep(nil), r.History...),
+		dim:      r.Dimension,
+		strategy: r.SplitStrategy,
+		torus:    r.Torus,
+	}
+	g.axes, _ = exactAxes(g.Zone, &g.Space, g.dim, g.strategy)
+	r.geo.Store(g)
+	return g
+}
+
+// Owns - Determine if a point belongs to the zone, exactly when its zone ID fixes its bounds
+func (g *Geometry) Owns(pt Point) bool {
+	if g.axes != nil {
+		return axesContain(g.axes, pt)
+	}
+	return g.Space.PointInRange(pt)
+}
+
+// Borders - Determine if another zone shares a face with this one, exactly when both zone IDs fix
+// their bounds
+func (g *Geometry) Borders(zone ZoneID, space *Range) bool {
+	if g.axes != nil {
+		if theirs, ok := exactAxes(zone, space, g.dim, g.strategy); ok {
+			return axesBorder(g.axes, theirs, g.torus)
+		}
+	}
+	return g.Space.border(space, g.torus)
+}
+
+// zonesBorder - Determine if two other servers' zones share a face, exactly when both zone IDs fix their bounds
+func (r *Region) zonesBorder(a, b *Neighbor) bool {
+	if axesA, ok := exactAxes(a.Zone, &a.Space, r.Dimension, r.SplitStrategy); ok {
+		if axesB, ok := exactAxes(b.Zone, &b.Space, r.Dimension, r.SplitStrategy); ok {
+			return axesBorder(axesA, axesB, r.Torus)
+		}
+	}
+	return a.Space.border(&b.Space, r.Torus)
+}
+
+// Owns - Determine if a point belongs to the region's zone, exactly when its zone ID fixes its bounds
+func (r *Region) Owns(pt Point) bool {
+	return r.Geometry().Owns(pt)
+}
+
+// Borders - Determine if a zone shares a face with the region, exactly when both zone IDs fix
+// their bounds
+func (r *Region) Borders(zone ZoneID, space *Range) bool {
+	return r.Geometry().Borders(zone, space)
+}
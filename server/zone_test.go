@@ -0,0 +1,157 @@
+package server
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestZoneIDRelations(t *testing.T) {
+	tests := []struct {
+		zone    ZoneID
+		parent  ZoneID
+		sibling ZoneID
+		root    bool
+	}{
+		{zone: "", root: true},
+		{zone: "0", parent: "", sibling: "1"},
+		{zone: "1", parent: "", sibling: "0"},
+		{zone: "0110", parent: "011", sibling: "0111"},
+		{zone: "0111", parent: "011", sibling: "0110"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.zone), func(t *testing.T) {
+			parent, ok := tt.zone.Parent()
+			if ok == tt.root || parent != tt.parent {
+				t.Fatalf("Parent is %q, %v", parent, ok)
+			}
+			sibling, ok := tt.zone.Sibling()
+			if ok == tt.root || sibling != tt.sibling {
+				t.Fatalf("Sibling is %q, %v", sibling, ok)
+			}
+			if !tt.root && parent.Child(tt.zone[len(tt.zone)-1] == '1') != tt.zone {
+				t.Fatalf("Parent %q does not lead back to %q", parent, tt.zone)
+			}
+		})
+	}
+}
+
+func TestZoneRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		zone     ZoneID
+		dim      int
+		strategy string
+		p1, p2   []float64
+		ok       bool
+	}{
+		{name: "whole space", zone: "", dim: 2, strategy: SplitLongest, p1: []float64{0, 0}, p2: []float64{1, 1}, ok: true},
+		{name: "upper half", zone: "1", dim: 2, strategy: SplitLongest, p1: []float64{0.5, 0}, p2: []float64{1, 1}, ok: true},
+		{name: "longest alternates", zone: "10", dim: 2, strategy: SplitLongest, p1: []float64{0.5, 0}, p2: []float64{1, 0.5}, ok: true},
+		{name: "longest evens out", zone: "011", dim: 2, strategy: SplitLongest, p1: []float64{0.25, 0.5}, p2: []float64{0.5, 1}, ok: true},
+		{name: "ordered cycles axes", zone: "1011", dim: 3, strategy: SplitOrdered, p1: []float64{0.75, 0, 0.5}, p2: []float64{1, 0.5, 1}, ok: true},
+		{name: "median does not halve", zone: "1", dim: 2, strategy: SplitMedian},
+		{name: "invalid digits", zone: "012", dim: 2, strategy: SplitLongest},
+		{name: "no dimensions", zone: "1", dim: 0, strategy: SplitLongest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng, ok := ZoneRange(tt.zone, tt.dim, tt.strategy)
+			if ok != tt.ok {
+				t.Fatalf("ZoneRange returned %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			want := &Range{P1: Point{tt.p1}, P2: Point{tt.p2}}
+			if !rng.Equal(want) {
+				t.Fatalf("Range is %v, want %v", rng, want)
+			}
+		})
+	}
+}
+
+func TestCoordBits(t *testing.T) {
+	tests := []struct {
+		x    float64
+		n    int
+		bits string
+	}{
+		{x: 0, n: 4, bits: "0000"},
+		{x: 0.5, n: 4, bits: "1000"},
+		{x: 0.625, n: 4, bits: "1010"},
+		{x: math.Nextafter(1, 0), n: 4, bits: "1111"},
+		{x: math.Ldexp(1, -60), n: 60, bits: strings.Repeat("0", 59) + "1"},
+		{x: math.Ldexp(1, -60), n: 59, bits: strings.Repeat("0", 59)},
+	}
+
+	for _, tt := range tests {
+		if bits := coordBits(tt.x, tt.n); bits != tt.bits {
+			t.Errorf("coordBits(%v, %d) is %s, want %s", tt.x, tt.n, bits, tt.bits)
+		}
+	}
+}
+
+func TestGeometryOwnsExactly(t *testing.T) {
+	// Sixty halvings along each axis, past where midpoints of float64 boxes stay distinct
+	deep := ZoneID(strings.Repeat("0", 119) + "1")
+	tests := []struct {
+		name string
+		zone ZoneID
+		pt   []float64
+		owns bool
+	}{
+		{name: "lower bound", zone: "1", pt: []float64{0.5, 0}, owns: true},
+		{name: "upper bound", zone: "0", pt: []float64{0.5, 0}},
+		{name: "inside", zone: "10", pt: []float64{0.75, 0.25}, owns: true},
+		{name: "outside", zone: "10", pt: []float64{0.75, 0.5}},
+		{name: "deep zone lower bound", zone: deep, pt: []float64{0, math.Ldexp(1, -60)}, owns: true},
+		{name: "deep zone below", zone: deep, pt: []float64{0, math.Ldexp(1, -61)}},
+		{name: "deep zone beside", zone: deep, pt: []float64{math.Ldexp(1, -60), math.Ldexp(1, -60)}},
+		{name: "outside the space", zone: "", pt: []float64{1, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng, _ := ZoneRange(tt.zone, 2, SplitLongest)
+			reg := &Region{Dimension: 2, Space: *rng, Zone: tt.zone, SplitStrategy: SplitLongest}
+			if owns := reg.Geometry().Owns(Point{tt.pt}); owns != tt.owns {
+				t.Fatalf("Zone %s owns %v is %v, want %v", tt.zone, tt.pt, owns, tt.owns)
+			}
+		})
+	}
+}
+
+func TestZonesBorderExactly(t *testing.T) {
+	deep := strings.Repeat("0", 120)
+	tests := []struct {
+		name    string
+		a, b    ZoneID
+		borders bool
+		torus   bool // Borders once the space wraps around
+	}{
+		{name: "halves", a: "0", b: "1", borders: true, torus: true},
+		{name: "quarter and opposite half", a: "01", b: "1", borders: true, torus: true},
+		{name: "diagonal quarters", a: "00", b: "11"},
+		{name: "zone and its parent", a: "0", b: "01"},
+		{name: "across the edge of the space", a: "00", b: "10", borders: true, torus: true},
+		{name: "ends of the first axis", a: "000", b: "101", torus: true},
+		{name: "deep halves", a: ZoneID(deep + "0"), b: ZoneID(deep + "1"), borders: true, torus: true},
+		{name: "deep zone and far corner", a: ZoneID(deep + "0"), b: "11"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := zoneAxes(tt.a, 2, SplitLongest)
+			b, _ := zoneAxes(tt.b, 2, SplitLongest)
+			if got := axesBorder(a, b, false); got != tt.borders || axesBorder(b, a, false) != got {
+				t.Fatalf("Border is %v, want %v", got, tt.borders)
+			}
+			if got := axesBorder(a, b, true); got != tt.torus || axesBorder(b, a, true) != got {
+				t.Fatalf("Border on a torus is %v, want %v", got, tt.torus)
+			}
+		})
+	}
+}